@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/Yorshik/final_task_sprint_1/internal/cli"
+	"github.com/Yorshik/final_task_sprint_1/internal/server/agent"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+func run(args []string, stderr io.Writer) int {
+	cfg := agent.ConfigFromEnv()
+	if code, ok := cli.Parse("agent", "Polls the orchestrator for tasks, computes them and sends the results back.", &cfg, args, stderr); !ok {
+		return code
+	}
+
+	log.Printf("Starting agent with %d workers for %s...", cfg.ComputingPower, cfg.OrchestratorURL)
+	if err := agent.StartAgent(cfg); err != nil {
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		output string
+	}{
+		{[]string{"--help"}, 0, "Usage: agent [flags]"},
+		{[]string{"-unknown"}, 2, "flag provided but not defined: -unknown"},
+		{[]string{"extra"}, 2, `unexpected argument "extra"`},
+		{[]string{"-computing-power", "0"}, 2, "invalid configuration"},
+	}
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		if code := run(tt.args, &stderr); code != tt.code {
+			t.Errorf("run(%q): expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+		if !strings.Contains(stderr.String(), tt.output) {
+			t.Errorf("run(%q): expected %q in output, got %q", tt.args, tt.output, stderr.String())
+		}
+	}
+}
@@ -1,18 +1,54 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/Yorshik/final_task_sprint_1/internal/server/agent"
 	"github.com/Yorshik/final_task_sprint_1/internal/server/orchestrator"
 )
 
 func main() {
+	orchestratorCfg := orchestrator.ConfigFromEnv()
+	orchestratorCfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	agentCfg := agent.ConfigFromEnv()
+	if _, ok := os.LookupEnv("ORCHESTRATOR_URL"); !ok {
+		agentCfg.OrchestratorURL = localURL(orchestratorCfg)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// The embedded agent keeps working until the orchestrator has drained its in-flight expressions.
+	agentCtx, stopAgent := context.WithCancel(context.Background())
+	agentDone := make(chan error, 1)
 	go func() {
-		log.Println("Starting orchestrator...")
-		orchestrator.StartServer()
+		log.Println("Starting agent...")
+		agentDone <- agent.Run(agentCtx, agentCfg)
 	}()
 
-	log.Println("Starting agent...")
-	agent.StartAgent()
+	log.Println("Starting orchestrator...")
+	err := orchestrator.Run(ctx, orchestratorCfg)
+	stopAgent()
+	if agentErr := <-agentDone; err == nil {
+		err = agentErr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func localURL(cfg orchestrator.Config) string {
+	scheme := "http"
+	if cfg.TLSCertFile != "" {
+		scheme = "https"
+	}
+	_, port, _ := net.SplitHostPort(cfg.Addr)
+	return scheme + "://" + net.JoinHostPort("localhost", port)
 }
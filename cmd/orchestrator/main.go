@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/Yorshik/final_task_sprint_1/internal/cli"
+	"github.com/Yorshik/final_task_sprint_1/internal/server/orchestrator"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stderr))
+}
+
+func run(args []string, stderr io.Writer) int {
+	cfg := orchestrator.ConfigFromEnv()
+	if code, ok := cli.Parse("orchestrator", "Accepts expressions over HTTP and hands out their tasks to agents.", &cfg, args, stderr); !ok {
+		return code
+	}
+
+	log.Printf("Starting orchestrator on %s...", cfg.Addr)
+	if err := orchestrator.StartServer(cfg); err != nil {
+		log.Println(err)
+		return 1
+	}
+	return 0
+}
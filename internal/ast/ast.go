@@ -2,46 +2,173 @@ package ast
 
 import (
 	"fmt"
+	"math/big"
+	"slices"
 	"strconv"
-	"strings"
 	"unicode"
 )
 
 type Node struct {
 	Value    float64
+	Exact    *big.Rat
 	Operator string
 	Left     *Node
 	Right    *Node
+	Function string
+	Args     []*Node
+	Variable string
+}
+
+type SyntaxError struct {
+	Position int
+	Token    string
+	Expected string
+}
+
+func (e *SyntaxError) Error() string {
+	token := "end of expression"
+	if e.Token != "" {
+		token = fmt.Sprintf("%q", e.Token)
+	}
+	return fmt.Sprintf("unexpected %s at position %d, expected %s", token, e.Position, e.Expected)
+}
+
+type arity struct {
+	min int
+	max int
+}
+
+var functions = map[string]arity{
+	"sqrt": {1, 1},
+	"sin":  {1, 1},
+	"cos":  {1, 1},
+	"log":  {1, 1},
+	"abs":  {1, 1},
+	"min":  {1, -1},
+	"max":  {1, -1},
+}
+
+func IsFunction(name string) bool {
+	_, ok := functions[name]
+	return ok
+}
+
+func IsIdentifier(name string) bool {
+	if name == "" || IsFunction(name) {
+		return false
+	}
+	for i, ch := range name {
+		if !isIdentStart(ch) && (i == 0 || !unicode.IsDigit(ch)) {
+			return false
+		}
+	}
+	return true
+}
+
+func isIdentStart(ch rune) bool {
+	return unicode.IsLetter(ch) || ch == '_'
+}
+
+func NewNumber(value float64) *Node {
+	exact, _ := new(big.Rat).SetString(strconv.FormatFloat(value, 'g', -1, 64))
+	return &Node{Value: value, Exact: exact}
+}
+
+func (n *Node) IsNumber() bool {
+	return n.Operator == "" && n.Function == "" && n.Variable == ""
+}
+
+func (n *Node) Clone() *Node {
+	if n == nil {
+		return nil
+	}
+	clone := *n
+	clone.Left = n.Left.Clone()
+	clone.Right = n.Right.Clone()
+	if n.Args != nil {
+		clone.Args = make([]*Node, len(n.Args))
+		for i, arg := range n.Args {
+			clone.Args[i] = arg.Clone()
+		}
+	}
+	return &clone
+}
+
+func (n *Node) Resolve(lookup func(name string) (float64, bool)) error {
+	if n.Variable != "" {
+		value, ok := lookup(n.Variable)
+		if !ok {
+			return fmt.Errorf("undefined variable: %s", n.Variable)
+		}
+		*n = *NewNumber(value)
+		return nil
+	}
+	for _, child := range []*Node{n.Left, n.Right} {
+		if child == nil {
+			continue
+		}
+		if err := child.Resolve(lookup); err != nil {
+			return err
+		}
+	}
+	for _, arg := range n.Args {
+		if err := arg.Resolve(lookup); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type Parser struct {
-	src string
-	pos int
+	tokens []Token
+	pos    int
 }
 
 func Parse(expression string) (*Node, error) {
-	expr := strings.ReplaceAll(expression, " ", "")
-	if expr == "" {
-		return nil, fmt.Errorf("error in expression")
+	tokens, err := Tokenize(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &Parser{tokens, 0}
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().Kind != TokenEOF {
+		return nil, p.errorf("operator or end of expression")
 	}
-	p := &Parser{expr, 0}
-	return p.parseExpr()
+	return node, nil
+}
+
+func (p *Parser) errorf(expected string) *SyntaxError {
+	return tokenError(p.peek(), expected)
 }
 
-func (p *Parser) next() rune {
-	if p.pos < len(p.src) {
-		ch := rune(p.src[p.pos])
+func tokenError(tok Token, expected string) *SyntaxError {
+	return &SyntaxError{Position: tok.Pos, Token: tok.Text, Expected: expected}
+}
+
+func (p *Parser) next() Token {
+	tok := p.tokens[p.pos]
+	if tok.Kind != TokenEOF {
 		p.pos++
-		return ch
 	}
-	return 0
+	return tok
+}
+
+func (p *Parser) peek() Token {
+	return p.tokens[p.pos]
 }
 
-func (p *Parser) peek() rune {
-	if p.pos < len(p.src) {
-		return rune(p.src[p.pos])
+func (p *Parser) match(kind TokenKind, texts ...string) (Token, bool) {
+	tok := p.peek()
+	if tok.Kind != kind {
+		return tok, false
+	}
+	if len(texts) > 0 && !slices.Contains(texts, tok.Text) {
+		return tok, false
 	}
-	return 0
+	return p.next(), true
 }
 
 func (p *Parser) parseExpr() (*Node, error) {
@@ -50,19 +177,16 @@ func (p *Parser) parseExpr() (*Node, error) {
 		return nil, err
 	}
 	for {
-		ch := p.peek()
-		if ch == '+' || ch == '-' {
-			p.next()
-			right, err := p.parseTerm()
-			if err != nil {
-				return nil, err
-			}
-			node = &Node{Operator: string(ch), Left: node, Right: right}
-		} else {
-			break
+		op, ok := p.match(TokenOperator, "+", "-")
+		if !ok {
+			return node, nil
+		}
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
 		}
+		node = &Node{Operator: op.Text, Left: node, Right: right}
 	}
-	return node, nil
 }
 
 func (p *Parser) parseTerm() (*Node, error) {
@@ -71,45 +195,110 @@ func (p *Parser) parseTerm() (*Node, error) {
 		return nil, err
 	}
 	for {
-		ch := p.peek()
-		if ch == '*' || ch == '/' {
-			p.next()
-			right, err := p.parseFactor()
-			if err != nil {
-				return nil, err
-			}
-			node = &Node{Operator: string(ch), Left: node, Right: right}
-		} else {
-			break
+		op, ok := p.match(TokenOperator, "*", "/")
+		if !ok {
+			return node, nil
+		}
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
 		}
+		node = &Node{Operator: op.Text, Left: node, Right: right}
 	}
-	return node, nil
 }
 
 func (p *Parser) parseFactor() (*Node, error) {
-	if p.peek() == '(' {
+	if _, ok := p.match(TokenOperator, "-"); ok {
+		operand, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &Node{Operator: "neg", Left: operand}, nil
+	}
+	if _, ok := p.match(TokenOperator, "+"); ok {
+		return p.parseFactor()
+	}
+	return p.parsePower()
+}
+
+func (p *Parser) parsePower() (*Node, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.match(TokenOperator, "^", "**"); !ok {
+		return node, nil
+	}
+	right, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Operator: "^", Left: node, Right: right}, nil
+}
+
+func (p *Parser) parsePrimary() (*Node, error) {
+	tok := p.peek()
+	switch tok.Kind {
+	case TokenNumber:
+		p.next()
+		return &Node{Value: tok.Value, Exact: tok.Exact}, nil
+	case TokenIdent:
+		return p.parseIdentifier()
+	case TokenLParen:
 		p.next()
 		node, err := p.parseExpr()
 		if err != nil {
 			return nil, err
 		}
-		if p.peek() != ')' {
-			return nil, fmt.Errorf("error in expression")
+		if _, ok := p.match(TokenRParen); !ok {
+			return nil, p.errorf("')'")
 		}
-		p.next()
 		return node, nil
+	default:
+		return nil, p.errorf("number, variable, function call or '('")
 	}
-	start := p.pos
-	for unicode.IsDigit(p.peek()) || p.peek() == '.' {
-		p.next()
+}
+
+func (p *Parser) parseIdentifier() (*Node, error) {
+	name := p.next()
+	fn, ok := functions[name.Text]
+	if !ok {
+		if p.peek().Kind == TokenLParen {
+			return nil, tokenError(name, "function name")
+		}
+		return &Node{Variable: name.Text}, nil
 	}
-	number := p.src[start:p.pos]
-	if number == "" {
-		return nil, fmt.Errorf("error in expression")
+	if _, ok := p.match(TokenLParen); !ok {
+		return nil, p.errorf("'(' after " + name.Text)
 	}
-	value, err := strconv.ParseFloat(number, 64)
-	if err != nil {
-		return nil, fmt.Errorf("error in expression")
+	node := &Node{Function: name.Text}
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		node.Args = append(node.Args, arg)
+		if _, ok := p.match(TokenComma); !ok {
+			break
+		}
+	}
+	if p.peek().Kind != TokenRParen {
+		return nil, p.errorf("',' or ')'")
+	}
+	if len(node.Args) < fn.min || (fn.max >= 0 && len(node.Args) > fn.max) {
+		return nil, p.errorf(fn.describe(name.Text))
+	}
+	p.next()
+	return node, nil
+}
+
+func (a arity) describe(name string) string {
+	switch {
+	case a.min == a.max:
+		return fmt.Sprintf("%s to take exactly %d argument(s)", name, a.min)
+	case a.max < 0:
+		return fmt.Sprintf("%s to take at least %d argument(s)", name, a.min)
+	default:
+		return fmt.Sprintf("%s to take %d to %d arguments", name, a.min, a.max)
 	}
-	return &Node{Value: value}, nil
 }
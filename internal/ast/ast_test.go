@@ -0,0 +1,338 @@
+package ast
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseUnary(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		expectErr  bool
+	}{
+		{"LeadingMinus", "-3+5", false},
+		{"MinusAfterOperator", "2*-4", false},
+		{"MinusBeforeParens", "-(1+2)", false},
+		{"DoubleMinus", "2--3", false},
+		{"UnaryPlus", "+2", false},
+		{"DanglingMinus", "2*-", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expression)
+			if tt.expectErr && err == nil {
+				t.Errorf("Expected error for %q", tt.expression)
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error for %q: %v", tt.expression, err)
+			}
+		})
+	}
+}
+
+func TestParseNegationNode(t *testing.T) {
+	node, err := Parse("-(1+2)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if node.Operator != "neg" {
+		t.Fatalf("Expected neg node, got %q", node.Operator)
+	}
+	if node.Left == nil || node.Left.Operator != "+" {
+		t.Errorf("Expected operand to be '+', got %+v", node.Left)
+	}
+	if node.Right != nil {
+		t.Errorf("Expected no right operand, got %+v", node.Right)
+	}
+}
+
+func eval(node *Node) float64 {
+	if node.Function != "" {
+		args := make([]float64, len(node.Args))
+		for i, arg := range node.Args {
+			args[i] = eval(arg)
+		}
+		switch node.Function {
+		case "sqrt":
+			return math.Sqrt(args[0])
+		case "abs":
+			return math.Abs(args[0])
+		case "max":
+			result := args[0]
+			for _, arg := range args[1:] {
+				result = math.Max(result, arg)
+			}
+			return result
+		}
+		return math.NaN()
+	}
+	if node.Variable != "" {
+		return math.NaN()
+	}
+	switch node.Operator {
+	case "":
+		return node.Value
+	case "neg":
+		return -eval(node.Left)
+	case "+":
+		return eval(node.Left) + eval(node.Right)
+	case "-":
+		return eval(node.Left) - eval(node.Right)
+	case "*":
+		return eval(node.Left) * eval(node.Right)
+	case "/":
+		return eval(node.Left) / eval(node.Right)
+	case "^":
+		return math.Pow(eval(node.Left), eval(node.Right))
+	}
+	return math.NaN()
+}
+
+func TestParsePower(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"2^3", 8},
+		{"2**3", 8},
+		{"2^3^2", 512},
+		{"2**3**2", 512},
+		{"(2^3)^2", 64},
+		{"-2^2", -4},
+		{"2^-1", 0.5},
+		{"2*3^2", 18},
+		{"2^3*2", 16},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			node, err := Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result := eval(node); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseFunctionCall(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"sqrt(16)", 4},
+		{"sqrt(9)+1", 4},
+		{"2*abs(-3)", 6},
+		{"max(1, 5, 3)", 5},
+		{"max(2)", 2},
+		{"sqrt(max(4, 16))^2", 16},
+		{"-sqrt(4)", -2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			node, err := Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result := eval(node); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseFunctionCallErrors(t *testing.T) {
+	tests := []string{
+		"foo(1)",
+		"sqrt",
+		"sqrt()",
+		"sqrt(1, 2)",
+		"max(1,)",
+		"max(1, 2",
+	}
+
+	for _, expression := range tests {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := Parse(expression); err == nil {
+				t.Errorf("Expected error for %q", expression)
+			}
+		})
+	}
+}
+
+func TestParseVariables(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		expectErr  bool
+	}{
+		{"SingleVariable", "x", false},
+		{"Expression", "a*x+b", false},
+		{"LongName", "rate_2 * 100", false},
+		{"VariableInCall", "sqrt(x)", false},
+		{"FunctionWithoutCall", "sqrt + 1", true},
+		{"CallOnVariable", "x(1)", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.expression)
+			if tt.expectErr && err == nil {
+				t.Errorf("Expected error for %q", tt.expression)
+			}
+			if !tt.expectErr && err != nil {
+				t.Errorf("Unexpected error for %q: %v", tt.expression, err)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	vars := map[string]float64{"a": 2, "x": 3, "b": 1}
+	lookup := func(name string) (float64, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+
+	node, err := Parse("a*x+max(b, x)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := node.Resolve(lookup); err != nil {
+		t.Fatalf("Unexpected resolve error: %v", err)
+	}
+	if result := eval(node); result != 9 {
+		t.Errorf("Expected 9, got %v", result)
+	}
+
+	node, err = Parse("a*y")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = node.Resolve(lookup)
+	if err == nil || err.Error() != "undefined variable: y" {
+		t.Errorf("Expected undefined variable error, got %v", err)
+	}
+}
+
+func TestIsIdentifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"x", true},
+		{"_tmp", true},
+		{"rate2", true},
+		{"", false},
+		{"2x", false},
+		{"a-b", false},
+		{"sqrt", false},
+	}
+
+	for _, tt := range tests {
+		if result := IsIdentifier(tt.name); result != tt.expected {
+			t.Errorf("IsIdentifier(%q): expected %v, got %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	node, err := Parse("a*x+max(b, 2)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	clone := node.Clone()
+	err = clone.Resolve(func(name string) (float64, bool) {
+		return 1, true
+	})
+	if err != nil {
+		t.Fatalf("Unexpected resolve error: %v", err)
+	}
+	if result := eval(clone); result != 3 {
+		t.Errorf("Expected 3, got %v", result)
+	}
+	if node.Left.Left.Variable != "a" || node.Right.Args[0].Variable != "b" {
+		t.Errorf("Expected original tree to keep its variables, got %+v", node)
+	}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	tests := []struct {
+		expression string
+		position   int
+		token      string
+		expected   string
+	}{
+		{"", 0, "", "number, variable, function call or '('"},
+		{"2 + * 3", 4, "*", "number, variable, function call or '('"},
+		{"(1 + 2", 6, "", "')'"},
+		{"1 + 2)", 5, ")", "operator or end of expression"},
+		{"1 2", 2, "2", "operator or end of expression"},
+		{"1 + 1.2.3", 4, "1.2.3", "number"},
+		{"foo(1)", 0, "foo", "function name"},
+		{"sqrt 4", 5, "4", "'(' after sqrt"},
+		{"sqrt(1, 2)", 9, ")", "sqrt to take exactly 1 argument(s)"},
+		{"max(1 2)", 6, "2", "',' or ')'"},
+		{"2 $ 3", 2, "$", "number, identifier, operator or parenthesis"},
+		{"1 + 2 3", 6, "3", "operator or end of expression"},
+		{"(1 2)", 3, "2", "')'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			_, err := Parse(tt.expression)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Expected SyntaxError, got %v", err)
+			}
+			if syntaxErr.Position != tt.position {
+				t.Errorf("Expected position %d, got %d", tt.position, syntaxErr.Position)
+			}
+			if syntaxErr.Token != tt.token {
+				t.Errorf("Expected token %q, got %q", tt.token, syntaxErr.Token)
+			}
+			if syntaxErr.Expected != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, syntaxErr.Expected)
+			}
+		})
+	}
+}
+
+func TestSyntaxErrorMessage(t *testing.T) {
+	err := &SyntaxError{Position: 4, Token: "*", Expected: "number"}
+	if msg := err.Error(); msg != `unexpected "*" at position 4, expected number` {
+		t.Errorf("Unexpected message: %s", msg)
+	}
+	err = &SyntaxError{Position: 6, Expected: "')'"}
+	if msg := err.Error(); msg != "unexpected end of expression at position 6, expected ')'" {
+		t.Errorf("Unexpected message: %s", msg)
+	}
+}
+
+func TestNewNumber(t *testing.T) {
+	tests := []struct {
+		value float64
+		exact string
+	}{
+		{0.1, "1/10"},
+		{-2.5, "-5/2"},
+		{3, "3"},
+		{1e-20, "1/100000000000000000000"},
+	}
+
+	for _, tt := range tests {
+		node := NewNumber(tt.value)
+		if node.Value != tt.value || !node.IsNumber() {
+			t.Errorf("Expected number node %v, got %+v", tt.value, node)
+		}
+		if node.Exact == nil || node.Exact.RatString() != tt.exact {
+			t.Errorf("Expected exact value %s for %v, got %v", tt.exact, tt.value, node.Exact)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package ast
+
+import (
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type TokenKind int
+
+const (
+	TokenEOF TokenKind = iota
+	TokenNumber
+	TokenIdent
+	TokenOperator
+	TokenLParen
+	TokenRParen
+	TokenComma
+)
+
+type Token struct {
+	Kind  TokenKind
+	Text  string
+	Value float64
+	Exact *big.Rat
+	Pos   int
+}
+
+var (
+	decimalLiteral = regexp.MustCompile(`^(\d(_?\d)*(\.(\d(_?\d)*)?)?|\.\d(_?\d)*)([eE][+-]?\d(_?\d)*)?$`)
+	hexLiteral     = regexp.MustCompile(`^0[xX]_?[0-9a-fA-F](_?[0-9a-fA-F])*$`)
+	binaryLiteral  = regexp.MustCompile(`^0[bB]_?[01](_?[01])*$`)
+)
+
+// Literals are kept exactly, so their size is bounded to keep parsing and storage cheap.
+const (
+	maxLiteralDigits   = 1000
+	maxLiteralExponent = 1000
+)
+
+type lexer struct {
+	src    string
+	pos    int
+	tokens []Token
+}
+
+func Tokenize(src string) ([]Token, error) {
+	l := &lexer{src: src}
+	for {
+		ch, size := utf8.DecodeRuneInString(src[l.pos:])
+		switch {
+		case size == 0:
+			l.tokens = append(l.tokens, Token{Kind: TokenEOF, Pos: l.pos})
+			return l.tokens, nil
+		case unicode.IsSpace(ch):
+			l.pos += size
+		case unicode.IsDigit(ch) || ch == '.':
+			if err := l.scanNumber(); err != nil {
+				return nil, err
+			}
+		case isIdentStart(ch):
+			l.scanIdent()
+		case strings.HasPrefix(src[l.pos:], "**"):
+			l.emit(TokenOperator, 2)
+		case strings.ContainsRune("+-*/^", ch):
+			l.emit(TokenOperator, size)
+		case ch == '(':
+			l.emit(TokenLParen, size)
+		case ch == ')':
+			l.emit(TokenRParen, size)
+		case ch == ',':
+			l.emit(TokenComma, size)
+		default:
+			return nil, &SyntaxError{
+				Position: l.pos,
+				Token:    string(ch),
+				Expected: "number, identifier, operator or parenthesis",
+			}
+		}
+	}
+}
+
+func isWordChar(ch rune) bool {
+	return isIdentStart(ch) || unicode.IsDigit(ch) || ch == '.'
+}
+
+func (l *lexer) emit(kind TokenKind, size int) {
+	l.tokens = append(l.tokens, Token{Kind: kind, Text: l.src[l.pos : l.pos+size], Pos: l.pos})
+	l.pos += size
+}
+
+func (l *lexer) scanIdent() {
+	end := l.pos
+	for end < len(l.src) {
+		ch, size := utf8.DecodeRuneInString(l.src[end:])
+		if !isIdentStart(ch) && !unicode.IsDigit(ch) {
+			break
+		}
+		end += size
+	}
+	l.emit(TokenIdent, end-l.pos)
+}
+
+func (l *lexer) scanNumber() error {
+	start := l.pos
+	prefixed := len(l.src) > start+1 && l.src[start] == '0' && strings.ContainsRune("xXbB", rune(l.src[start+1]))
+	end := start
+	for end < len(l.src) {
+		ch, size := utf8.DecodeRuneInString(l.src[end:])
+		exponentSign := (ch == '+' || ch == '-') && !prefixed && end > start && strings.ContainsRune("eE", rune(l.src[end-1]))
+		if !isWordChar(ch) && !exponentSign {
+			break
+		}
+		end += size
+	}
+
+	text := l.src[start:end]
+	value, exact, ok := parseNumber(text)
+	if !ok {
+		return &SyntaxError{Position: start, Token: text, Expected: "number"}
+	}
+	l.tokens = append(l.tokens, Token{Kind: TokenNumber, Text: text, Value: value, Exact: exact, Pos: start})
+	l.pos = end
+	return nil
+}
+
+func parseNumber(text string) (float64, *big.Rat, bool) {
+	digits := strings.ReplaceAll(text, "_", "")
+	switch {
+	case hexLiteral.MatchString(text):
+		return parseInteger(digits[2:], 16)
+	case binaryLiteral.MatchString(text):
+		return parseInteger(digits[2:], 2)
+	case decimalLiteral.MatchString(text):
+		mantissa, exponent, _ := strings.Cut(strings.ToLower(digits), "e")
+		if len(strings.Replace(mantissa, ".", "", 1)) > maxLiteralDigits {
+			return 0, nil, false
+		}
+		if exponent != "" {
+			if e, err := strconv.Atoi(exponent); err != nil || e < -maxLiteralExponent || e > maxLiteralExponent {
+				return 0, nil, false
+			}
+		}
+		exact, ok := new(big.Rat).SetString(digits)
+		if !ok {
+			return 0, nil, false
+		}
+		return approximate(exact), exact, true
+	default:
+		return 0, nil, false
+	}
+}
+
+func parseInteger(digits string, base int) (float64, *big.Rat, bool) {
+	if len(digits) > maxLiteralDigits {
+		return 0, nil, false
+	}
+	n, ok := new(big.Int).SetString(digits, base)
+	if !ok {
+		return 0, nil, false
+	}
+	exact := new(big.Rat).SetInt(n)
+	return approximate(exact), exact, true
+}
+
+// approximate keeps literals beyond float64 range finite so that they can be encoded; only
+// exact and decimal modes, which use Exact, can evaluate them.
+func approximate(exact *big.Rat) float64 {
+	value, _ := exact.Float64()
+	if math.IsInf(value, 0) {
+		return math.Copysign(math.MaxFloat64, value)
+	}
+	return value
+}
@@ -0,0 +1,170 @@
+package ast
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, err := Tokenize("max(x_1,\t2.5)**2\n- 1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []Token{
+		{Kind: TokenIdent, Text: "max", Pos: 0},
+		{Kind: TokenLParen, Text: "(", Pos: 3},
+		{Kind: TokenIdent, Text: "x_1", Pos: 4},
+		{Kind: TokenComma, Text: ",", Pos: 7},
+		{Kind: TokenNumber, Text: "2.5", Value: 2.5, Pos: 9},
+		{Kind: TokenRParen, Text: ")", Pos: 12},
+		{Kind: TokenOperator, Text: "**", Pos: 13},
+		{Kind: TokenNumber, Text: "2", Value: 2, Pos: 15},
+		{Kind: TokenOperator, Text: "-", Pos: 17},
+		{Kind: TokenNumber, Text: "1", Value: 1, Pos: 19},
+		{Kind: TokenEOF, Pos: 20},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d: %+v", len(expected), len(tokens), tokens)
+	}
+	for i, tok := range tokens {
+		tok.Exact = nil
+		if tok != expected[i] {
+			t.Errorf("Token %d: expected %+v, got %+v", i, expected[i], tok)
+		}
+	}
+}
+
+func TestTokenizeNumbers(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected float64
+		exact    string
+	}{
+		{"42", 42, "42"},
+		{"3.25", 3.25, "13/4"},
+		{".5", 0.5, "1/2"},
+		{"5.", 5, "5"},
+		{"0.1", 0.1, "1/10"},
+		{"1e-9", 1e-9, "1/1000000000"},
+		{"2.5E+3", 2500, "2500"},
+		{"1_000_000", 1000000, "1000000"},
+		{"0x1F", 31, "31"},
+		{"0XfF", 255, "255"},
+		{"0b101", 5, "5"},
+		{"0b_1010_1010", 170, "170"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			tokens, err := Tokenize(tt.text)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tokens[0].Kind != TokenNumber || tokens[0].Value != tt.expected {
+				t.Errorf("Expected number %v, got %+v", tt.expected, tokens[0])
+			}
+			if tokens[0].Exact == nil || tokens[0].Exact.RatString() != tt.exact {
+				t.Errorf("Expected exact value %s, got %v", tt.exact, tokens[0].Exact)
+			}
+			if tokens[1].Kind != TokenEOF {
+				t.Errorf("Expected a single number token, got %+v", tokens)
+			}
+		})
+	}
+}
+
+func TestTokenizeLargeNumbers(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected float64
+		exact    string
+	}{
+		{"1e400", math.MaxFloat64, "1" + strings.Repeat("0", 400)},
+		{"1e-400", 0, "1/1" + strings.Repeat("0", 400)},
+		{"0x1_0000_0000_0000_0000", 1 << 64, "18446744073709551616"},
+		{"0b1" + strings.Repeat("0", 64), 1 << 64, "18446744073709551616"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			tokens, err := Tokenize(tt.text)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if tokens[0].Value != tt.expected {
+				t.Errorf("Expected approximate value %v, got %v", tt.expected, tokens[0].Value)
+			}
+			if tokens[0].Exact == nil || tokens[0].Exact.RatString() != tt.exact {
+				t.Errorf("Expected exact value %s, got %v", tt.exact, tokens[0].Exact)
+			}
+		})
+	}
+}
+
+func TestTokenizeHugeExponentsAreCheap(t *testing.T) {
+	expression := strings.Repeat("1e-999999+", 100) + "1"
+	start := time.Now()
+	if _, err := Tokenize(expression); err == nil {
+		t.Fatal("Expected out-of-range literals to be rejected")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected rejection to be cheap, took %v", elapsed)
+	}
+}
+
+func TestTokenizeInvalidNumbers(t *testing.T) {
+	tests := []string{
+		"1.2.3",
+		"1__0",
+		"1_",
+		"1e",
+		"1e+",
+		"0x",
+		"0xG",
+		"0b102",
+		"12abc",
+		"1e1001",
+		"1e-999999",
+		"1e99999999999999999999",
+		"1" + strings.Repeat("0", maxLiteralDigits),
+		"0x" + strings.Repeat("f", maxLiteralDigits+1),
+		".",
+	}
+
+	for _, text := range tests {
+		t.Run(text, func(t *testing.T) {
+			_, err := Tokenize(text)
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) {
+				t.Fatalf("Expected SyntaxError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseRejectsAdjacentNumbers(t *testing.T) {
+	for _, expression := range []string{"1 2+3", "1\t2", "0x1 0b1"} {
+		if _, err := Parse(expression); err == nil {
+			t.Errorf("Expected error for %q", expression)
+		}
+	}
+}
+
+func TestParseUnicode(t *testing.T) {
+	node, err := Parse("π * 2")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if node.Left.Variable != "π" {
+		t.Errorf("Expected variable π, got %+v", node.Left)
+	}
+
+	_, err = Parse("2 × 3")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Position != 2 || syntaxErr.Token != "×" {
+		t.Errorf("Expected error on '×' at byte 2, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+)
+
+type Config interface {
+	RegisterFlags(fs *flag.FlagSet)
+	Validate() error
+}
+
+func Parse(name, description string, cfg Config, args []string, stderr io.Writer) (int, bool) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: %s [flags]\n", name)
+		fmt.Fprintln(stderr, "\n"+description)
+		fmt.Fprintln(stderr, "Every flag defaults to the environment variable shown in parentheses.\n\nFlags:")
+		fs.PrintDefaults()
+	}
+	cfg.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, false
+		}
+		return 2, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2, false
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(stderr, "invalid configuration:", err)
+		return 2, false
+	}
+	return 0, true
+}
@@ -0,0 +1,58 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+type testConfig struct {
+	workers int
+}
+
+func (c *testConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.workers, "workers", c.workers, "number of workers (WORKERS)")
+}
+
+func (c *testConfig) Validate() error {
+	if c.workers <= 0 {
+		return errors.New("workers must be positive")
+	}
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		ok     bool
+		output string
+	}{
+		{[]string{"-workers", "3"}, 0, true, ""},
+		{nil, 0, true, ""},
+		{[]string{"-help"}, 0, false, "Usage: test [flags]"},
+		{[]string{"--help"}, 0, false, "number of workers (WORKERS)"},
+		{[]string{"-unknown"}, 2, false, "flag provided but not defined"},
+		{[]string{"-workers", "x"}, 2, false, "invalid value"},
+		{[]string{"extra"}, 2, false, `unexpected argument "extra"`},
+		{[]string{"-workers", "0"}, 2, false, "invalid configuration: workers must be positive"},
+	}
+	for _, tt := range tests {
+		var stderr bytes.Buffer
+		cfg := testConfig{workers: 1}
+		code, ok := Parse("test", "Runs tests.", &cfg, tt.args, &stderr)
+		if code != tt.code || ok != tt.ok {
+			t.Errorf("Parse(%q): expected (%d, %v), got (%d, %v)", tt.args, tt.code, tt.ok, code, ok)
+		}
+		if !strings.Contains(stderr.String(), tt.output) {
+			t.Errorf("Parse(%q): expected %q in output, got %q", tt.args, tt.output, stderr.String())
+		}
+	}
+
+	cfg := testConfig{workers: 1}
+	if _, ok := Parse("test", "Runs tests.", &cfg, []string{"-workers", "3"}, &bytes.Buffer{}); !ok || cfg.workers != 3 {
+		t.Errorf("Expected flags to override the config, got %+v", cfg)
+	}
+}
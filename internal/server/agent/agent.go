@@ -2,82 +2,565 @@ package agent
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
-	"strconv"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
 type Task struct {
-	ID            int     `json:"id"`
-	Arg1          float64 `json:"arg1"`
-	Arg2          float64 `json:"arg2"`
-	Operation     string  `json:"operation"`
-	OperationTime int     `json:"operation_time"`
+	ID            int       `json:"id"`
+	Arg1          float64   `json:"arg1"`
+	Arg2          float64   `json:"arg2"`
+	Args          []float64 `json:"args,omitempty"`
+	Operands      []string  `json:"operands,omitempty"`
+	Operation     string    `json:"operation"`
+	OperationTime int       `json:"operation_time"`
+	Mode          string    `json:"mode,omitempty"`
+	Precision     uint      `json:"precision,omitempty"`
+	Lease         string    `json:"lease,omitempty"`
 }
 
-func compute(task Task) float64 {
-	time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
+type Result struct {
+	ID     int        `json:"id"`
+	Result float64    `json:"result"`
+	Value  string     `json:"value,omitempty"`
+	Mode   string     `json:"mode,omitempty"`
+	Error  *TaskError `json:"error,omitempty"`
+	Lease  string     `json:"lease,omitempty"`
+}
+
+type TaskError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+const (
+	CodeDivisionByZero = "division_by_zero"
+	CodeNonFinite      = "non_finite_result"
+	CodeDomain         = "domain_error"
+	CodeInvalidTask    = "invalid_task"
+)
+
+const (
+	maxExactExponent = 1 << 16
+	maxExactBits     = 1 << 20
+)
+
+func (e *TaskError) Error() string {
+	return e.Message
+}
+
+func newTaskError(code, format string, args ...interface{}) *TaskError {
+	return &TaskError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func compute(ctx context.Context, task Task) (float64, error) {
+	if err := sleep(ctx, time.Duration(task.OperationTime)*time.Millisecond); err != nil {
+		return 0, err
+	}
+	var result float64
 	switch task.Operation {
 	case "+":
-		return task.Arg1 + task.Arg2
+		result = task.Arg1 + task.Arg2
 	case "-":
-		return task.Arg1 - task.Arg2
+		result = task.Arg1 - task.Arg2
 	case "*":
-		return task.Arg1 * task.Arg2
+		result = task.Arg1 * task.Arg2
 	case "/":
-		return task.Arg1 / task.Arg2
+		if task.Arg2 == 0 {
+			return 0, newTaskError(CodeDivisionByZero, "division by zero")
+		}
+		result = task.Arg1 / task.Arg2
+	case "^":
+		result = math.Pow(task.Arg1, task.Arg2)
+	case "neg":
+		result = -task.Arg1
 	default:
-		return 0
+		var err error
+		if result, err = computeFunction(task.Operation, task.Args); err != nil {
+			return 0, err
+		}
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, newTaskError(CodeNonFinite, "operation %s produced a non-finite result", task.Operation)
 	}
+	return result, nil
 }
 
-func worker() {
-	client := &http.Client{}
-	for {
-		resp, err := client.Get("http://localhost:8080/internal/task")
-		if err != nil || resp.StatusCode == http.StatusNotFound {
-			time.Sleep(100 * time.Millisecond)
-			continue
+func computeFunction(name string, args []float64) (float64, error) {
+	if len(args) == 0 {
+		return 0, newTaskError(CodeInvalidTask, "no arguments for %s", name)
+	}
+	switch name {
+	case "sqrt":
+		return math.Sqrt(args[0]), nil
+	case "sin":
+		return math.Sin(args[0]), nil
+	case "cos":
+		return math.Cos(args[0]), nil
+	case "log":
+		return math.Log(args[0]), nil
+	case "abs":
+		return math.Abs(args[0]), nil
+	case "min":
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	case "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	default:
+		return 0, newTaskError(CodeInvalidTask, "operation %s is not supported", name)
+	}
+}
+
+func computeExact(ctx context.Context, task Task) (*big.Rat, error) {
+	if err := sleep(ctx, time.Duration(task.OperationTime)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if len(task.Operands) == 0 {
+		return nil, newTaskError(CodeInvalidTask, "no operands for %s", task.Operation)
+	}
+	args := make([]*big.Rat, len(task.Operands))
+	for i, operand := range task.Operands {
+		arg, ok := new(big.Rat).SetString(operand)
+		if !ok {
+			return nil, newTaskError(CodeInvalidTask, "invalid operand %q", operand)
 		}
+		args[i] = arg
+	}
 
-		var data struct {
-			Task Task `json:"task"`
+	switch task.Operation {
+	case "neg":
+		return new(big.Rat).Neg(args[0]), nil
+	case "abs":
+		return new(big.Rat).Abs(args[0]), nil
+	case "min":
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) < 0 {
+				result = arg
+			}
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-			resp.Body.Close()
-			continue
+		return result, nil
+	case "max":
+		result := args[0]
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) > 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	}
+	if len(args) != 2 {
+		return nil, newTaskError(CodeInvalidTask, "operation %s expects 2 operands, got %d", task.Operation, len(args))
+	}
+	switch task.Operation {
+	case "+":
+		return new(big.Rat).Add(args[0], args[1]), nil
+	case "-":
+		return new(big.Rat).Sub(args[0], args[1]), nil
+	case "*":
+		return new(big.Rat).Mul(args[0], args[1]), nil
+	case "/":
+		if args[1].Sign() == 0 {
+			return nil, newTaskError(CodeDivisionByZero, "division by zero")
 		}
-		resp.Body.Close()
+		return new(big.Rat).Quo(args[0], args[1]), nil
+	case "^":
+		return ratPow(args[0], args[1])
+	default:
+		return nil, newTaskError(CodeInvalidTask, "operation %s is not supported in exact mode", task.Operation)
+	}
+}
 
-		result := compute(data.Task)
+func ratPow(base, exponent *big.Rat) (*big.Rat, error) {
+	if !exponent.IsInt() {
+		return nil, newTaskError(CodeDomain, "non-integer exponent %s in exact mode", exponent.RatString())
+	}
+	if !exponent.Num().IsInt64() || abs(exponent.Num().Int64()) > maxExactExponent {
+		return nil, newTaskError(CodeDomain, "exponent %s is too large", exponent.RatString())
+	}
+	n := exponent.Num().Int64()
+	if int64(base.Num().BitLen()+base.Denom().BitLen())*abs(n) > maxExactBits {
+		return nil, newTaskError(CodeDomain, "result of raising to the power %s is too large", exponent.RatString())
+	}
+	if n < 0 {
+		if base.Sign() == 0 {
+			return nil, newTaskError(CodeDivisionByZero, "division by zero")
+		}
+		base = new(big.Rat).Inv(base)
+		n = -n
+	}
+	e := big.NewInt(n)
+	num := new(big.Int).Exp(base.Num(), e, nil)
+	denom := new(big.Int).Exp(base.Denom(), e, nil)
+	return new(big.Rat).SetFrac(num, denom), nil
+}
 
-		reqBody, err := json.Marshal(map[string]interface{}{
-			"id":     data.Task.ID,
-			"result": result,
-		})
-		if err != nil {
-			continue
+func computeDecimal(ctx context.Context, task Task) (*big.Float, error) {
+	if err := sleep(ctx, time.Duration(task.OperationTime)*time.Millisecond); err != nil {
+		return nil, err
+	}
+	if len(task.Operands) == 0 {
+		return nil, newTaskError(CodeInvalidTask, "no operands for %s", task.Operation)
+	}
+	if task.Precision == 0 || task.Precision > big.MaxPrec {
+		return nil, newTaskError(CodeInvalidTask, "invalid precision %d", task.Precision)
+	}
+	args := make([]*big.Float, len(task.Operands))
+	for i, operand := range task.Operands {
+		arg, ok := newDecimal(task.Precision).SetString(operand)
+		if !ok {
+			return nil, newTaskError(CodeInvalidTask, "invalid operand %q", operand)
 		}
+		if arg.IsInf() {
+			return nil, newTaskError(CodeNonFinite, "operand %q is not finite", operand)
+		}
+		args[i] = arg
+	}
 
-		resp, err = client.Post("http://localhost:8080/internal/task", "application/json", bytes.NewBuffer(reqBody))
-		if err != nil {
+	result, err := decimalOperation(task.Operation, args, task.Precision)
+	if err != nil {
+		return nil, err
+	}
+	if result.IsInf() {
+		return nil, newTaskError(CodeNonFinite, "operation %s produced a non-finite result", task.Operation)
+	}
+	return result, nil
+}
+
+func decimalOperation(operation string, args []*big.Float, precision uint) (*big.Float, error) {
+	result := newDecimal(precision)
+	switch operation {
+	case "neg":
+		return result.Neg(args[0]), nil
+	case "abs":
+		return result.Abs(args[0]), nil
+	case "sqrt":
+		if args[0].Sign() < 0 {
+			return nil, newTaskError(CodeDomain, "square root of negative number")
+		}
+		return result.Sqrt(args[0]), nil
+	case "min":
+		result.Set(args[0])
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) < 0 {
+				result.Set(arg)
+			}
+		}
+		return result, nil
+	case "max":
+		result.Set(args[0])
+		for _, arg := range args[1:] {
+			if arg.Cmp(result) > 0 {
+				result.Set(arg)
+			}
+		}
+		return result, nil
+	}
+	if len(args) != 2 {
+		return nil, newTaskError(CodeInvalidTask, "operation %s expects 2 operands, got %d", operation, len(args))
+	}
+	switch operation {
+	case "+":
+		return result.Add(args[0], args[1]), nil
+	case "-":
+		return result.Sub(args[0], args[1]), nil
+	case "*":
+		return result.Mul(args[0], args[1]), nil
+	case "/":
+		if args[1].Sign() == 0 {
+			return nil, newTaskError(CodeDivisionByZero, "division by zero")
+		}
+		return result.Quo(args[0], args[1]), nil
+	case "^":
+		return decimalPow(args[0], args[1], precision)
+	default:
+		return nil, newTaskError(CodeInvalidTask, "operation %s is not supported in decimal mode", operation)
+	}
+}
+
+func newDecimal(precision uint) *big.Float {
+	return new(big.Float).SetPrec(precision)
+}
+
+func decimalPow(base, exponent *big.Float, precision uint) (*big.Float, error) {
+	if !exponent.IsInt() {
+		return nil, newTaskError(CodeDomain, "non-integer exponent %s in decimal mode", exponent.Text('g', -1))
+	}
+	n, accuracy := exponent.Int64()
+	if accuracy != big.Exact || abs(n) > maxExactExponent {
+		return nil, newTaskError(CodeDomain, "exponent %s is too large", exponent.Text('g', -1))
+	}
+	negative := n < 0
+	n = abs(n)
+	if negative && base.Sign() == 0 {
+		return nil, newTaskError(CodeDivisionByZero, "division by zero")
+	}
+
+	result := newDecimal(precision).SetInt64(1)
+	square := newDecimal(precision).Set(base)
+	for ; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			if result.Mul(result, square).IsInf() {
+				return nil, newTaskError(CodeNonFinite, "power produced a non-finite result")
+			}
+		}
+		if n > 1 && square.Mul(square, square).IsInf() {
+			return nil, newTaskError(CodeNonFinite, "power produced a non-finite result")
+		}
+	}
+	if negative {
+		result.Quo(newDecimal(precision).SetInt64(1), result)
+	}
+	return result, nil
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func process(ctx context.Context, task Task) Result {
+	result := Result{ID: task.ID, Mode: task.Mode, Lease: task.Lease}
+	var err error
+	switch task.Mode {
+	case "exact":
+		var value *big.Rat
+		if value, err = computeExact(ctx, task); err == nil {
+			approx, _ := value.Float64()
+			result.Result = approximate(approx)
+			result.Value = value.RatString()
+		}
+	case "decimal":
+		var value *big.Float
+		if value, err = computeDecimal(ctx, task); err == nil {
+			approx, _ := value.Float64()
+			result.Result = approximate(approx)
+			result.Value = value.Text('g', -1)
+		}
+	default:
+		result.Result, err = compute(ctx, task)
+	}
+	if err != nil {
+		var taskErr *TaskError
+		if !errors.As(err, &taskErr) {
+			taskErr = newTaskError(CodeInvalidTask, "%v", err)
+		}
+		result = Result{ID: task.ID, Mode: task.Mode, Error: taskErr, Lease: task.Lease}
+	}
+	return result
+}
+
+func approximate(value float64) float64 {
+	if math.IsInf(value, 0) {
+		return 0
+	}
+	return value
+}
+
+const leaseCheckInterval = 200 * time.Millisecond
+
+var errNoTask = errors.New("no task available")
+
+func worker(ctx context.Context, client *http.Client, cfg Config, b *breaker, m *metrics) {
+	taskURL := cfg.taskURL()
+	poll := newBackoff(cfg)
+	for ctx.Err() == nil {
+		if wait := b.wait(time.Now()); wait > 0 {
+			sleep(ctx, wait)
 			continue
 		}
-		resp.Body.Close()
+		m.polls.Add(1)
+		task, err := fetchTask(ctx, client, taskURL)
+		switch {
+		case err == nil:
+			b.success()
+			poll.reset()
+			m.tasks.Add(1)
+			handleTask(ctx, client, taskURL, task, cfg.ShutdownTimeout)
+		case errors.Is(err, errNoTask):
+			b.success()
+			m.emptyPolls.Add(1)
+			sleep(ctx, poll.next())
+		case ctx.Err() == nil:
+			b.failure(time.Now())
+			m.failedPolls.Add(1)
+			sleep(ctx, poll.next())
+		}
 	}
 }
 
-func StartAgent() {
-	power, _ := strconv.Atoi(os.Getenv("COMPUTING_POWER"))
-	if power <= 0 {
-		power = 1
+func fetchTask(ctx context.Context, client *http.Client, taskURL string) (Task, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, taskURL, nil)
+	if err != nil {
+		return Task{}, err
 	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Task{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return Task{}, errNoTask
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Task{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var data struct {
+		Task Task `json:"task"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return Task{}, err
+	}
+	return data.Task, nil
+}
+
+func handleTask(ctx context.Context, client *http.Client, taskURL string, task Task, grace time.Duration) {
+	leaseURL := fmt.Sprintf("%s/%d?lease=%s", taskURL, task.ID, task.Lease)
+	// The task outlives the worker context so that a shutdown lets it finish within the grace period.
+	taskCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	go watchLease(taskCtx, cancel, client, leaseURL)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sleep(taskCtx, grace)
+			cancel()
+		case <-taskCtx.Done():
+		}
+	}()
 
-	for i := 0; i < power; i++ {
-		go worker()
+	result := process(taskCtx, task)
+	if taskCtx.Err() != nil {
+		if ctx.Err() != nil {
+			releaseTask(ctx, client, leaseURL)
+		}
+		return
 	}
 
-	select {}
+	reqBody, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodPost, taskURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+func releaseTask(ctx context.Context, client *http.Client, leaseURL string) {
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodDelete, leaseURL, nil)
+	if err != nil {
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+func watchLease(ctx context.Context, cancel context.CancelFunc, client *http.Client, url string) {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				continue
+			}
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+				cancel()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func StartAgent(cfg Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return Run(ctx, cfg)
+}
+
+func Run(ctx context.Context, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	client, err := cfg.httpClient()
+	if err != nil {
+		return err
+	}
+
+	m := &metrics{}
+	if cfg.MetricsAddr != "" {
+		listener, err := net.Listen("tcp", cfg.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m)
+		srv := &http.Server{Handler: mux}
+		go srv.Serve(listener)
+		defer srv.Close()
+	}
+
+	b := newBreaker(cfg, m)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.ComputingPower; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(ctx, client, cfg, b, m)
+		}()
+	}
+	wg.Wait()
+	log.Println("Agent stopped")
+	return nil
 }
@@ -1,17 +1,29 @@
 package agent
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/Yorshik/final_task_sprint_1/internal/server/orchestrator"
 )
 
-type Result struct {
-	ID     int     `json:"id"`
-	Result float64 `json:"result"`
+func testConfig(url string) Config {
+	return Config{
+		OrchestratorURL:  url,
+		RequestTimeout:   time.Second,
+		ComputingPower:   2,
+		PollInterval:     10 * time.Millisecond,
+		MaxPollInterval:  50 * time.Millisecond,
+		PollJitter:       0.5,
+		BreakerThreshold: 3,
+		BreakerCooldown:  100 * time.Millisecond,
+	}
 }
 
 func TestComputeAddition(t *testing.T) {
@@ -21,7 +33,10 @@ func TestComputeAddition(t *testing.T) {
 		Operation:     "+",
 		OperationTime: 0,
 	}
-	result := compute(task)
+	result, err := compute(context.Background(), task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	if result != 5 {
 		t.Errorf("expected 5, got %v", result)
 	}
@@ -34,7 +49,10 @@ func TestComputeSubtraction(t *testing.T) {
 		Operation:     "-",
 		OperationTime: 0,
 	}
-	result := compute(task)
+	result, err := compute(context.Background(), task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	if result != 3 {
 		t.Errorf("expected 3, got %v", result)
 	}
@@ -47,7 +65,10 @@ func TestComputeMultiplication(t *testing.T) {
 		Operation:     "*",
 		OperationTime: 0,
 	}
-	result := compute(task)
+	result, err := compute(context.Background(), task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	if result != 12 {
 		t.Errorf("expected 12, got %v", result)
 	}
@@ -60,22 +81,301 @@ func TestComputeDivision(t *testing.T) {
 		Operation:     "/",
 		OperationTime: 0,
 	}
-	result := compute(task)
+	result, err := compute(context.Background(), task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	if result != 3 {
 		t.Errorf("expected 3, got %v", result)
 	}
 }
 
+func TestComputePower(t *testing.T) {
+	task := Task{
+		Arg1:          2,
+		Arg2:          10,
+		Operation:     "^",
+		OperationTime: 0,
+	}
+	result, err := compute(context.Background(), task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != 1024 {
+		t.Errorf("expected 1024, got %v", result)
+	}
+}
+
+func TestComputeNegation(t *testing.T) {
+	task := Task{
+		Arg1:          4,
+		Operation:     "neg",
+		OperationTime: 0,
+	}
+	result, err := compute(context.Background(), task)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != -4 {
+		t.Errorf("expected -4, got %v", result)
+	}
+}
+
+func TestComputeFunctions(t *testing.T) {
+	tests := []struct {
+		operation string
+		args      []float64
+		expected  float64
+	}{
+		{"sqrt", []float64{16}, 4},
+		{"sin", []float64{0}, 0},
+		{"cos", []float64{0}, 1},
+		{"log", []float64{1}, 0},
+		{"abs", []float64{-7}, 7},
+		{"min", []float64{4, -1, 3}, -1},
+		{"max", []float64{4, -1, 3}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			task := Task{Args: tt.args, Operation: tt.operation}
+			result, err := compute(context.Background(), task)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
 func TestComputeUnknownOperation(t *testing.T) {
 	task := Task{
 		Arg1:          2,
 		Arg2:          3,
-		Operation:     "^",
+		Operation:     "%",
 		OperationTime: 0,
 	}
-	result := compute(task)
-	if result != 0 {
-		t.Errorf("expected 0, got %v", result)
+	_, err := compute(context.Background(), task)
+	var taskErr *TaskError
+	if !errors.As(err, &taskErr) || taskErr.Code != CodeInvalidTask {
+		t.Errorf("expected invalid task error, got %v", err)
+	}
+}
+
+func TestComputeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		task Task
+		code string
+	}{
+		{"DivisionByZero", Task{Arg1: 1, Arg2: 0, Operation: "/"}, CodeDivisionByZero},
+		{"ZeroByZero", Task{Arg1: 0, Arg2: 0, Operation: "/"}, CodeDivisionByZero},
+		{"Overflow", Task{Arg1: 1e308, Arg2: 10, Operation: "*"}, CodeNonFinite},
+		{"PowerOverflow", Task{Arg1: 10, Arg2: 1000, Operation: "^"}, CodeNonFinite},
+		{"NegativeSqrt", Task{Args: []float64{-1}, Operation: "sqrt"}, CodeNonFinite},
+		{"LogOfZero", Task{Args: []float64{0}, Operation: "log"}, CodeNonFinite},
+		{"MissingArgs", Task{Operation: "sqrt"}, CodeInvalidTask},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compute(context.Background(), tt.task)
+			var taskErr *TaskError
+			if !errors.As(err, &taskErr) || taskErr.Code != tt.code {
+				t.Errorf("expected %s error, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestProcessError(t *testing.T) {
+	tests := []struct {
+		name string
+		task Task
+		code string
+	}{
+		{"Float", Task{ID: 1, Arg1: 1, Arg2: 0, Operation: "/", Lease: "abc"}, CodeDivisionByZero},
+		{"Exact", Task{ID: 2, Operands: []string{"1", "0"}, Operation: "/", Mode: "exact"}, CodeDivisionByZero},
+		{"Decimal", Task{ID: 3, Operands: []string{"-1"}, Operation: "sqrt", Mode: "decimal", Precision: 64}, CodeDomain},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := process(context.Background(), tt.task)
+			if result.ID != tt.task.ID || result.Error == nil || result.Error.Code != tt.code {
+				t.Errorf("expected %s error for task %d, got %+v", tt.code, tt.task.ID, result)
+			}
+			if result.Value != "" || result.Result != 0 {
+				t.Errorf("expected no value with error, got %+v", result)
+			}
+			if result.Lease != tt.task.Lease {
+				t.Errorf("expected lease %q, got %q", tt.task.Lease, result.Lease)
+			}
+		})
+	}
+}
+
+func TestProcessEchoesLease(t *testing.T) {
+	task := Task{ID: 4, Arg1: 2, Arg2: 3, Operation: "+", Lease: "lease-4"}
+	result := process(context.Background(), task)
+	if result.Lease != "lease-4" || result.Result != 5 {
+		t.Errorf("expected result 5 with lease-4, got %+v", result)
+	}
+}
+
+func TestProcessHugeDecimal(t *testing.T) {
+	task := Task{ID: 1, Operands: []string{"1e400", "1e400"}, Operation: "*", Mode: "decimal", Precision: 64}
+	result := process(context.Background(), task)
+	if result.Error != nil || !strings.HasSuffix(result.Value, "e+800") {
+		t.Errorf("expected value around 1e+800, got %+v", result)
+	}
+	if _, err := json.Marshal(result); err != nil {
+		t.Errorf("expected result to be encodable, got %v", err)
+	}
+}
+
+func TestComputeExact(t *testing.T) {
+	tests := []struct {
+		operation string
+		operands  []string
+		expected  string
+	}{
+		{"+", []string{"1/3", "1/6"}, "1/2"},
+		{"-", []string{"1/3", "1"}, "-2/3"},
+		{"*", []string{"1/3", "3"}, "1"},
+		{"/", []string{"1", "3"}, "1/3"},
+		{"^", []string{"2/3", "3"}, "8/27"},
+		{"^", []string{"2", "-2"}, "1/4"},
+		{"neg", []string{"5/7"}, "-5/7"},
+		{"abs", []string{"-5/7"}, "5/7"},
+		{"min", []string{"1/2", "1/3", "2"}, "1/3"},
+		{"max", []string{"1/2", "1/3", "2"}, "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			task := Task{Operands: tt.operands, Operation: tt.operation, Mode: "exact"}
+			result, err := computeExact(context.Background(), task)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result.RatString() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, result.RatString())
+			}
+		})
+	}
+}
+
+func TestComputeExactErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		operands  []string
+	}{
+		{"DivisionByZero", "/", []string{"1", "0"}},
+		{"ZeroToNegativePower", "^", []string{"0", "-1"}},
+		{"FractionalExponent", "^", []string{"2", "1/2"}},
+		{"HugeExponent", "^", []string{"2", "100000000"}},
+		{"HugeResult", "^", []string{"1" + strings.Repeat("0", 65536), "65536"}},
+		{"UnsupportedFunction", "sqrt", []string{"2"}},
+		{"InvalidOperand", "+", []string{"1", "abc"}},
+		{"MissingOperand", "+", []string{"1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := Task{Operands: tt.operands, Operation: tt.operation, Mode: "exact"}
+			if _, err := computeExact(context.Background(), task); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestProcessExact(t *testing.T) {
+	task := Task{ID: 7, Operands: []string{"1", "3"}, Operation: "/", Mode: "exact"}
+	result := process(context.Background(), task)
+	if result.ID != 7 || result.Value != "1/3" || result.Mode != "exact" || result.Error != nil {
+		t.Errorf("expected id 7 and exact value 1/3, got %v", result)
+	}
+}
+
+func TestComputeDecimal(t *testing.T) {
+	tests := []struct {
+		operation string
+		operands  []string
+		expected  string
+	}{
+		{"+", []string{"0.1", "0.2"}, "0.3"},
+		{"-", []string{"1", "0.9"}, "0.1"},
+		{"*", []string{"1.5", "4"}, "6"},
+		{"/", []string{"1", "3"}, "0.33333333333333333333"},
+		{"^", []string{"1.1", "2"}, "1.21"},
+		{"^", []string{"2", "-2"}, "0.25"},
+		{"sqrt", []string{"2"}, "1.4142135623730950488"},
+		{"neg", []string{"2.5"}, "-2.5"},
+		{"abs", []string{"-2.5"}, "2.5"},
+		{"min", []string{"0.5", "0.25", "2"}, "0.25"},
+		{"max", []string{"0.5", "0.25", "2"}, "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.operation, func(t *testing.T) {
+			task := Task{Operands: tt.operands, Operation: tt.operation, Mode: "decimal", Precision: 128}
+			result, err := computeDecimal(context.Background(), task)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if text := result.Text('g', 20); text != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, text)
+			}
+		})
+	}
+}
+
+func TestComputeDecimalErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation string
+		operands  []string
+		precision uint
+	}{
+		{"DivisionByZero", "/", []string{"1", "0"}, 128},
+		{"NegativeSqrt", "sqrt", []string{"-1"}, 128},
+		{"FractionalExponent", "^", []string{"2", "0.5"}, 128},
+		{"UnsupportedFunction", "sin", []string{"1"}, 128},
+		{"InvalidOperand", "+", []string{"1", "abc"}, 128},
+		{"MissingPrecision", "+", []string{"1", "2"}, 0},
+		{"InfiniteOperand", "-", []string{"+Inf", "+Inf"}, 64},
+		{"PowerOverflow", "^", []string{"9.99e+65535", "65536"}, 64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := Task{Operands: tt.operands, Operation: tt.operation, Mode: "decimal", Precision: tt.precision}
+			if _, err := computeDecimal(context.Background(), task); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestProcessDecimalOverflow(t *testing.T) {
+	power := process(context.Background(), Task{ID: 1, Operands: []string{"10", "65536"}, Operation: "^", Mode: "decimal", Precision: 64})
+	if power.Error != nil {
+		t.Fatalf("unexpected error: %v", power.Error)
+	}
+	overflow := process(context.Background(), Task{ID: 2, Operands: []string{power.Value, "65536"}, Operation: "^", Mode: "decimal", Precision: 64})
+	if overflow.Error == nil || overflow.Error.Code != CodeNonFinite {
+		t.Fatalf("expected non-finite error, got %+v", overflow)
+	}
+	for _, operands := range [][]string{{"+Inf", "+Inf"}, {"-Inf", "1"}} {
+		result := process(context.Background(), Task{ID: 3, Operands: operands, Operation: "-", Mode: "decimal", Precision: 64})
+		if result.Error == nil || result.Error.Code != CodeNonFinite {
+			t.Errorf("expected non-finite error for %v, got %+v", operands, result)
+		}
 	}
 }
 
@@ -87,8 +387,11 @@ func TestComputeWithDelay(t *testing.T) {
 		OperationTime: 100,
 	}
 	start := time.Now()
-	result := compute(task)
+	result, err := compute(context.Background(), task)
 	duration := time.Since(start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	if result != 5 {
 		t.Errorf("expected 5, got %v", result)
 	}
@@ -97,6 +400,38 @@ func TestComputeWithDelay(t *testing.T) {
 	}
 }
 
+func TestComputeCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := compute(ctx, Task{Arg1: 2, Arg2: 3, Operation: "+", OperationTime: 1000})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline error, got %v", err)
+	}
+	if duration := time.Since(start); duration > 500*time.Millisecond {
+		t.Errorf("expected sleep to be interrupted, took %v", duration)
+	}
+}
+
+func TestWatchLease(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("lease") != "abc" {
+			t.Errorf("expected lease to be sent, got %q", r.URL.RawQuery)
+		}
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchLease(ctx, cancel, server.Client(), server.URL+"/internal/task/1?lease=abc")
+	select {
+	case <-ctx.Done():
+	case <-time.After(1 * time.Second):
+		t.Fatal("expected revoked lease to cancel the task")
+	}
+}
+
 func TestWorkerIntegration(t *testing.T) {
 	tasks := make(chan Task, 1)
 	results := make(chan Result, 1)
@@ -120,39 +455,10 @@ func TestWorkerIntegration(t *testing.T) {
 		}
 	}))
 	defer server.Close()
-	go func() {
-		client := &http.Client{}
-		for {
-			resp, err := client.Get(server.URL + "/internal/task")
-			if err != nil || resp.StatusCode == http.StatusNotFound {
-				time.Sleep(10 * time.Millisecond) // Уменьшено для теста
-				continue
-			}
 
-			var data struct {
-				Task Task `json:"task"`
-			}
-			if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-				t.Error("Error decoding task:", err)
-				resp.Body.Close()
-				continue
-			}
-			resp.Body.Close()
-
-			resultVal := compute(data.Task)
-
-			reqBody, _ := json.Marshal(Result{
-				ID:     data.Task.ID,
-				Result: resultVal,
-			})
-			resp, err = client.Post(server.URL+"/internal/task", "application/json", bytes.NewBuffer(reqBody))
-			if err != nil {
-				t.Error("Error sending result:", err)
-				continue
-			}
-			resp.Body.Close()
-		}
-	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go Run(ctx, testConfig(server.URL))
 	task := Task{
 		ID:            1,
 		Arg1:          2,
@@ -170,3 +476,132 @@ func TestWorkerIntegration(t *testing.T) {
 		t.Fatal("Timeout waiting for worker result")
 	}
 }
+
+func TestRun(t *testing.T) {
+	t.Setenv("TIME_MULTIPLICATIONS_MS", "10")
+	t.Setenv("TIME_ADDITION_MS", "10")
+	o := orchestrator.NewOrchestrator()
+	server := httptest.NewServer(o.Router())
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- Run(ctx, testConfig(server.URL))
+	}()
+
+	resp, err := http.Post(server.URL+"/api/v1/calculate", "application/json", strings.NewReader(`{"expression": "(1 + 2) * (3 + 4)"}`))
+	if err != nil {
+		t.Fatalf("failed to send request: %v", err)
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		resp, err := http.Get(server.URL + "/api/v1/expressions/" + created.ID)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		var data struct {
+			Expression orchestrator.Expression `json:"expression"`
+		}
+		json.NewDecoder(resp.Body).Decode(&data)
+		resp.Body.Close()
+		if data.Expression.Status != "pending" {
+			if data.Expression.Status != "completed" || data.Expression.Result == nil || *data.Expression.Result != 21 {
+				t.Errorf("expected result 21, got %+v", data.Expression)
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timeout waiting for expression")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Run did not return after cancellation")
+	}
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	if err := Run(context.Background(), Config{OrchestratorURL: "localhost:8080"}); err == nil {
+		t.Error("expected invalid config to be rejected")
+	}
+}
+
+func TestWorkerShutdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		grace  time.Duration
+		method string
+	}{
+		{"FinishesTask", 1 * time.Second, "POST"},
+		{"ReleasesTask", 10 * time.Millisecond, "DELETE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leased := make(chan struct{})
+			requests := make(chan string, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == "GET" && r.URL.Path == "/internal/task":
+					select {
+					case <-leased:
+						w.WriteHeader(http.StatusNotFound)
+					default:
+						close(leased)
+						json.NewEncoder(w).Encode(map[string]Task{"task": {ID: 7, Arg1: 2, Arg2: 3, Operation: "+", OperationTime: 300, Lease: "abc"}})
+					}
+				case r.Method == "GET":
+					w.WriteHeader(http.StatusOK)
+				default:
+					requests <- r.Method + " " + r.URL.RequestURI()
+					w.WriteHeader(http.StatusNoContent)
+				}
+			}))
+			defer server.Close()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cfg := testConfig(server.URL)
+			cfg.ShutdownTimeout = tt.grace
+			m := &metrics{}
+			done := make(chan struct{})
+			go func() {
+				worker(ctx, server.Client(), cfg, newBreaker(cfg, m), m)
+				close(done)
+			}()
+			<-leased
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("worker did not stop")
+			}
+			select {
+			case request := <-requests:
+				if !strings.HasPrefix(request, tt.method+" ") {
+					t.Errorf("expected %s request, got %q", tt.method, request)
+				}
+				if tt.method == "DELETE" && request != "DELETE /internal/task/7?lease=abc" {
+					t.Errorf("expected task to be released with its lease, got %q", request)
+				}
+			default:
+				t.Errorf("expected %s request before the worker stopped", tt.method)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	OrchestratorURL       string
+	RequestTimeout        time.Duration
+	ComputingPower        int
+	TLSCAFile             string
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify bool
+	ShutdownTimeout       time.Duration
+	PollInterval          time.Duration
+	MaxPollInterval       time.Duration
+	PollJitter            float64
+	BreakerThreshold      int
+	BreakerCooldown       time.Duration
+	MetricsAddr           string
+}
+
+func ConfigFromEnv() Config {
+	return Config{
+		OrchestratorURL:       getEnv("ORCHESTRATOR_URL", "http://localhost:8080"),
+		RequestTimeout:        time.Duration(getEnvInt("AGENT_REQUEST_TIMEOUT_MS", 10000)) * time.Millisecond,
+		ComputingPower:        getEnvInt("COMPUTING_POWER", 1),
+		TLSCAFile:             os.Getenv("AGENT_TLS_CA_FILE"),
+		TLSCertFile:           os.Getenv("AGENT_TLS_CERT_FILE"),
+		TLSKeyFile:            os.Getenv("AGENT_TLS_KEY_FILE"),
+		TLSInsecureSkipVerify: os.Getenv("AGENT_TLS_INSECURE_SKIP_VERIFY") == "true",
+		ShutdownTimeout:       time.Duration(getEnvInt("AGENT_SHUTDOWN_TIMEOUT_MS", 10000)) * time.Millisecond,
+		PollInterval:          time.Duration(getEnvInt("AGENT_POLL_INTERVAL_MS", 100)) * time.Millisecond,
+		MaxPollInterval:       time.Duration(getEnvInt("AGENT_MAX_POLL_INTERVAL_MS", 5000)) * time.Millisecond,
+		PollJitter:            getEnvFloat("AGENT_POLL_JITTER", 0.5),
+		BreakerThreshold:      getEnvInt("AGENT_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:       time.Duration(getEnvInt("AGENT_BREAKER_COOLDOWN_MS", 10000)) * time.Millisecond,
+		MetricsAddr:           os.Getenv("AGENT_METRICS_ADDR"),
+	}
+}
+
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.OrchestratorURL, "orchestrator-url", c.OrchestratorURL, "base URL of the orchestrator (ORCHESTRATOR_URL)")
+	fs.DurationVar(&c.RequestTimeout, "request-timeout", c.RequestTimeout, "timeout of a single request to the orchestrator (AGENT_REQUEST_TIMEOUT_MS)")
+	fs.IntVar(&c.ComputingPower, "computing-power", c.ComputingPower, "number of parallel workers (COMPUTING_POWER)")
+	fs.StringVar(&c.TLSCAFile, "tls-ca", c.TLSCAFile, "PEM file with CA certificates to trust (AGENT_TLS_CA_FILE)")
+	fs.StringVar(&c.TLSCertFile, "tls-cert", c.TLSCertFile, "PEM client certificate (AGENT_TLS_CERT_FILE)")
+	fs.StringVar(&c.TLSKeyFile, "tls-key", c.TLSKeyFile, "PEM client private key (AGENT_TLS_KEY_FILE)")
+	fs.BoolVar(&c.TLSInsecureSkipVerify, "tls-insecure-skip-verify", c.TLSInsecureSkipVerify, "do not verify the orchestrator certificate (AGENT_TLS_INSECURE_SKIP_VERIFY)")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long a running task may finish after a shutdown signal (AGENT_SHUTDOWN_TIMEOUT_MS)")
+	fs.DurationVar(&c.PollInterval, "poll-interval", c.PollInterval, "delay before the first retry when the queue is empty (AGENT_POLL_INTERVAL_MS)")
+	fs.DurationVar(&c.MaxPollInterval, "max-poll-interval", c.MaxPollInterval, "upper bound of the exponential polling backoff (AGENT_MAX_POLL_INTERVAL_MS)")
+	fs.Float64Var(&c.PollJitter, "poll-jitter", c.PollJitter, "fraction of the backoff delay randomized between workers, 0 to 1 (AGENT_POLL_JITTER)")
+	fs.IntVar(&c.BreakerThreshold, "breaker-threshold", c.BreakerThreshold, "consecutive failed polls after which polling is paused (AGENT_BREAKER_THRESHOLD)")
+	fs.DurationVar(&c.BreakerCooldown, "breaker-cooldown", c.BreakerCooldown, "how long polling stays paused before probing the orchestrator again (AGENT_BREAKER_COOLDOWN_MS)")
+	fs.StringVar(&c.MetricsAddr, "metrics-addr", c.MetricsAddr, "address to serve /metrics on, empty to disable (AGENT_METRICS_ADDR)")
+}
+
+func (c Config) Validate() error {
+	u, err := url.Parse(c.OrchestratorURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid orchestrator URL %q", c.OrchestratorURL)
+	}
+	if c.RequestTimeout <= 0 {
+		return errors.New("request timeout must be positive")
+	}
+	if c.ComputingPower <= 0 {
+		return errors.New("computing power must be positive")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("TLS certificate and key must be set together")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("shutdown timeout must not be negative")
+	}
+	if c.PollInterval <= 0 || c.MaxPollInterval < c.PollInterval {
+		return errors.New("poll interval must be positive and not exceed the maximum poll interval")
+	}
+	if c.PollJitter < 0 || c.PollJitter > 1 {
+		return errors.New("poll jitter must be between 0 and 1")
+	}
+	if c.BreakerThreshold <= 0 || c.BreakerCooldown <= 0 {
+		return errors.New("breaker threshold and cooldown must be positive")
+	}
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			return fmt.Errorf("invalid metrics address %q", c.MetricsAddr)
+		}
+	}
+	return nil
+}
+
+func (c Config) taskURL() string {
+	return strings.TrimRight(c.OrchestratorURL, "/") + "/internal/task"
+}
+
+func (c Config) httpClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.TLSInsecureSkipVerify}
+	if c.TLSCAFile != "" {
+		pem, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.TLSCAFile)
+		}
+	}
+	if c.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: c.RequestTimeout}, nil
+}
+
+func getEnv(key, defaultVal string) string {
+	if val, ok := os.LookupEnv(key); ok {
+		return val
+	}
+	return defaultVal
+}
+
+func getEnvFloat(key string, defaultVal float64) float64 {
+	if val, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(val, 64); err == nil {
+			return f
+		}
+	}
+	return defaultVal
+}
+
+func getEnvInt(key string, defaultVal int) int {
+	if val, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.Atoi(val); err == nil {
+			return i
+		}
+	}
+	return defaultVal
+}
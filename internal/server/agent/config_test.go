@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"encoding/pem"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("ORCHESTRATOR_URL", "https://orchestrator:8443")
+	t.Setenv("AGENT_REQUEST_TIMEOUT_MS", "2500")
+	t.Setenv("COMPUTING_POWER", "4")
+	t.Setenv("AGENT_TLS_INSECURE_SKIP_VERIFY", "true")
+	t.Setenv("AGENT_SHUTDOWN_TIMEOUT_MS", "500")
+	t.Setenv("AGENT_POLL_JITTER", "0.25")
+
+	cfg := ConfigFromEnv()
+	if cfg.OrchestratorURL != "https://orchestrator:8443" || cfg.RequestTimeout != 2500*time.Millisecond || cfg.ComputingPower != 4 || !cfg.TLSInsecureSkipVerify || cfg.ShutdownTimeout != 500*time.Millisecond || cfg.PollJitter != 0.25 {
+		t.Errorf("unexpected config from env: %+v", cfg)
+	}
+	if got := cfg.taskURL(); got != "https://orchestrator:8443/internal/task" {
+		t.Errorf("unexpected task URL %q", got)
+	}
+
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-orchestrator-url", "http://10.0.0.1:8080/", "-request-timeout", "3s"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.OrchestratorURL != "http://10.0.0.1:8080/" || cfg.RequestTimeout != 3*time.Second || cfg.ComputingPower != 4 {
+		t.Errorf("expected flags to override env, got %+v", cfg)
+	}
+	if got := cfg.taskURL(); got != "http://10.0.0.1:8080/internal/task" {
+		t.Errorf("unexpected task URL %q", got)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := testConfig("http://localhost:8080")
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		ok     bool
+	}{
+		{"Valid", func(*Config) {}, true},
+		{"NoScheme", func(c *Config) { c.OrchestratorURL = "localhost:8080" }, false},
+		{"BadScheme", func(c *Config) { c.OrchestratorURL = "ftp://localhost" }, false},
+		{"ZeroTimeout", func(c *Config) { c.RequestTimeout = 0 }, false},
+		{"NoWorkers", func(c *Config) { c.ComputingPower = 0 }, false},
+		{"CertWithoutKey", func(c *Config) { c.TLSCertFile = "cert.pem" }, false},
+		{"NegativeShutdownTimeout", func(c *Config) { c.ShutdownTimeout = -time.Second }, false},
+		{"ZeroPollInterval", func(c *Config) { c.PollInterval = 0 }, false},
+		{"MaxBelowPollInterval", func(c *Config) { c.MaxPollInterval = time.Millisecond }, false},
+		{"JitterAboveOne", func(c *Config) { c.PollJitter = 1.5 }, false},
+		{"NoBreakerThreshold", func(c *Config) { c.BreakerThreshold = 0 }, false},
+		{"BadMetricsAddr", func(c *Config) { c.MetricsAddr = "9100" }, false},
+		{"MetricsAddr", func(c *Config) { c.MetricsAddr = "127.0.0.1:9100" }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			if err := cfg.Validate(); (err == nil) != tt.ok {
+				t.Errorf("expected ok=%v, got %v", tt.ok, err)
+			}
+		})
+	}
+}
+
+func TestConfigHTTPClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	os.WriteFile(caFile, caPEM, 0o600)
+
+	cfg := Config{OrchestratorURL: server.URL, RequestTimeout: time.Second, ComputingPower: 1}
+	client, err := cfg.httpClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("expected untrusted certificate to be rejected")
+	}
+
+	cfg.TLSCAFile = caFile
+	client, err = cfg.httpClient()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("expected trusted CA to be accepted, got %v", err)
+	}
+	resp.Body.Close()
+
+	cfg.TLSCAFile = filepath.Join(dir, "missing.pem")
+	if _, err := cfg.httpClient(); err == nil {
+		t.Error("expected missing CA file to be rejected")
+	}
+}
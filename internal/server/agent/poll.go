@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	jitter  float64
+	current time.Duration
+}
+
+func newBackoff(cfg Config) *backoff {
+	return &backoff{min: cfg.PollInterval, max: cfg.MaxPollInterval, jitter: cfg.PollJitter}
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current = min(2*b.current, b.max)
+	}
+	// Workers that backed off together must not wake up together.
+	return b.current - time.Duration(rand.Float64()*b.jitter*float64(b.current))
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+}
+
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	metrics   *metrics
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newBreaker(cfg Config, m *metrics) *breaker {
+	return &breaker{threshold: cfg.BreakerThreshold, cooldown: cfg.BreakerCooldown, metrics: m}
+}
+
+func (b *breaker) wait(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return 0
+	}
+	if now.Before(b.openUntil) {
+		return b.openUntil.Sub(now)
+	}
+	// Half-open: let this caller probe the orchestrator and keep the others out until it reports back.
+	b.openUntil = now.Add(b.cooldown)
+	return 0
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures >= b.threshold {
+		log.Println("Orchestrator is reachable again, resuming polling")
+		b.metrics.circuitOpen.Store(false)
+	}
+	b.failures = 0
+}
+
+func (b *breaker) failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.failures < b.threshold {
+		return
+	}
+	if b.failures == b.threshold {
+		log.Printf("Orchestrator is unreachable, pausing polling for %v", b.cooldown)
+		b.metrics.circuitOpens.Add(1)
+		b.metrics.circuitOpen.Store(true)
+	}
+	b.openUntil = now.Add(b.cooldown)
+}
+
+type metrics struct {
+	polls        atomic.Int64
+	emptyPolls   atomic.Int64
+	failedPolls  atomic.Int64
+	tasks        atomic.Int64
+	circuitOpens atomic.Int64
+	circuitOpen  atomic.Bool
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	open := 0
+	if m.circuitOpen.Load() {
+		open = 1
+	}
+	for _, metric := range []struct {
+		name, kind, help string
+		value            int64
+	}{
+		{"agent_polls_total", "counter", "Requests for a task sent to the orchestrator.", m.polls.Load()},
+		{"agent_empty_polls_total", "counter", "Polls answered with an empty queue.", m.emptyPolls.Load()},
+		{"agent_failed_polls_total", "counter", "Polls that failed because the orchestrator was unreachable or returned an error.", m.failedPolls.Load()},
+		{"agent_tasks_total", "counter", "Tasks received from the orchestrator.", m.tasks.Load()},
+		{"agent_circuit_opens_total", "counter", "Times polling was paused because the orchestrator was unreachable.", m.circuitOpens.Load()},
+		{"agent_circuit_open", "gauge", "Whether polling is currently paused.", int64(open)},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", metric.name, metric.help, metric.name, metric.kind, metric.name, metric.value)
+	}
+}
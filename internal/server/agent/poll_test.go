@@ -0,0 +1,140 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(Config{PollInterval: 100 * time.Millisecond, MaxPollInterval: time.Second, PollJitter: 0.5})
+	for _, expected := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		expected *= time.Millisecond
+		delay := b.next()
+		if delay > expected || delay < expected/2 {
+			t.Errorf("expected delay within [%v, %v], got %v", expected/2, expected, delay)
+		}
+	}
+	b.reset()
+	if delay := b.next(); delay > 100*time.Millisecond {
+		t.Errorf("expected reset to restart from the poll interval, got %v", delay)
+	}
+
+	exact := newBackoff(Config{PollInterval: 100 * time.Millisecond, MaxPollInterval: time.Second})
+	exact.next()
+	if delay := exact.next(); delay != 200*time.Millisecond {
+		t.Errorf("expected no jitter, got %v", delay)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	m := &metrics{}
+	b := newBreaker(Config{BreakerThreshold: 2, BreakerCooldown: time.Second}, m)
+	now := time.Now()
+
+	b.failure(now)
+	if wait := b.wait(now); wait != 0 {
+		t.Errorf("expected breaker to stay closed below the threshold, got wait %v", wait)
+	}
+	b.failure(now)
+	if wait := b.wait(now); wait != time.Second {
+		t.Errorf("expected breaker to open for the cooldown, got wait %v", wait)
+	}
+	if !m.circuitOpen.Load() || m.circuitOpens.Load() != 1 {
+		t.Errorf("expected open circuit to be reported, got open=%v opens=%d", m.circuitOpen.Load(), m.circuitOpens.Load())
+	}
+
+	later := now.Add(time.Second)
+	if wait := b.wait(later); wait != 0 {
+		t.Errorf("expected one probe after the cooldown, got wait %v", wait)
+	}
+	if wait := b.wait(later); wait != time.Second {
+		t.Errorf("expected other callers to wait while probing, got wait %v", wait)
+	}
+	b.failure(later)
+	if wait := b.wait(later); wait != time.Second || m.circuitOpens.Load() != 1 {
+		t.Errorf("expected failed probe to reopen the breaker, got wait %v opens %d", wait, m.circuitOpens.Load())
+	}
+
+	b.success()
+	if wait := b.wait(later); wait != 0 || m.circuitOpen.Load() {
+		t.Errorf("expected success to close the breaker, got wait %v open %v", wait, m.circuitOpen.Load())
+	}
+}
+
+func TestWorkerBacksOffOnEmptyQueue(t *testing.T) {
+	var polls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	cfg := testConfig(server.URL)
+	m := &metrics{}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	worker(ctx, server.Client(), cfg, newBreaker(cfg, m), m)
+
+	// A fixed 10ms interval would poll about 30 times; backing off to 50ms keeps it well below that.
+	if n := polls.Load(); n < 3 || n > 15 {
+		t.Errorf("expected backoff to limit polls, got %d", n)
+	}
+	if m.emptyPolls.Load() != polls.Load() || m.failedPolls.Load() != 0 {
+		t.Errorf("expected every poll to be counted as empty, got %d empty and %d failed of %d", m.emptyPolls.Load(), m.failedPolls.Load(), polls.Load())
+	}
+}
+
+func TestWorkerOpensBreaker(t *testing.T) {
+	var polls atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		polls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	cfg := testConfig(server.URL)
+	cfg.BreakerCooldown = time.Second
+	m := &metrics{}
+	b := newBreaker(cfg, m)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	for range 2 {
+		go func() {
+			worker(ctx, server.Client(), cfg, b, m)
+			done <- struct{}{}
+		}()
+	}
+	<-done
+	<-done
+
+	// The second worker may already have a poll in flight when the breaker opens.
+	if n := polls.Load(); n < int64(cfg.BreakerThreshold) || n > int64(cfg.BreakerThreshold)+1 {
+		t.Errorf("expected polling to stop after %d failures, got %d polls", cfg.BreakerThreshold, n)
+	}
+	if m.failedPolls.Load() != polls.Load() || !m.circuitOpen.Load() || m.circuitOpens.Load() != 1 {
+		t.Errorf("unexpected metrics: %d failed, open=%v, opens=%d", m.failedPolls.Load(), m.circuitOpen.Load(), m.circuitOpens.Load())
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	m := &metrics{}
+	m.polls.Add(3)
+	m.emptyPolls.Add(2)
+	m.circuitOpen.Store(true)
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	for _, line := range []string{"agent_polls_total 3", "agent_empty_polls_total 2", "agent_failed_polls_total 0", "agent_circuit_open 1", "# TYPE agent_circuit_open gauge"} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("expected %q in metrics output:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package orchestrator
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+type Config struct {
+	Addr            string
+	StoragePath     string
+	TLSCertFile     string
+	TLSKeyFile      string
+	ShutdownTimeout time.Duration
+}
+
+func ConfigFromEnv() Config {
+	return Config{
+		Addr:            getEnv("ORCHESTRATOR_ADDR", ":8080"),
+		StoragePath:     getEnv("STORAGE_PATH", "data/orchestrator.log"),
+		TLSCertFile:     os.Getenv("ORCHESTRATOR_TLS_CERT_FILE"),
+		TLSKeyFile:      os.Getenv("ORCHESTRATOR_TLS_KEY_FILE"),
+		ShutdownTimeout: time.Duration(getEnvInt("ORCHESTRATOR_SHUTDOWN_TIMEOUT_MS", 30000)) * time.Millisecond,
+	}
+}
+
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Addr, "addr", c.Addr, "address to listen on (ORCHESTRATOR_ADDR)")
+	fs.StringVar(&c.StoragePath, "storage", c.StoragePath, "state log file, empty to keep state in memory only (STORAGE_PATH)")
+	fs.StringVar(&c.TLSCertFile, "tls-cert", c.TLSCertFile, "PEM server certificate (ORCHESTRATOR_TLS_CERT_FILE)")
+	fs.StringVar(&c.TLSKeyFile, "tls-key", c.TLSKeyFile, "PEM server private key (ORCHESTRATOR_TLS_KEY_FILE)")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "how long to wait for in-flight expressions on shutdown (ORCHESTRATOR_SHUTDOWN_TIMEOUT_MS)")
+}
+
+func (c Config) Validate() error {
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("invalid listen address %q", c.Addr)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("TLS certificate and key must be set together")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("shutdown timeout must not be negative")
+	}
+	return nil
+}
+
+func getEnv(key, defaultVal string) string {
+	if val, ok := os.LookupEnv(key); ok {
+		return val
+	}
+	return defaultVal
+}
@@ -0,0 +1,36 @@
+package orchestrator
+
+import (
+	"flag"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	t.Setenv("ORCHESTRATOR_ADDR", "127.0.0.1:9090")
+	t.Setenv("STORAGE_PATH", "")
+	t.Setenv("ORCHESTRATOR_SHUTDOWN_TIMEOUT_MS", "1500")
+
+	cfg := ConfigFromEnv()
+	if cfg.Addr != "127.0.0.1:9090" || cfg.StoragePath != "" || cfg.ShutdownTimeout != 1500*time.Millisecond {
+		t.Errorf("Unexpected config from env: %+v", cfg)
+	}
+
+	fs := flag.NewFlagSet("orchestrator", flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	if err := fs.Parse([]string{"-addr", ":7070"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cfg.Addr != ":7070" {
+		t.Errorf("Expected flag to override env, got %q", cfg.Addr)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	for _, invalid := range []Config{{Addr: "8080"}, {Addr: ":8080", TLSKeyFile: "key.pem"}, {Addr: ":8080", ShutdownTimeout: -time.Second}} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type Event struct {
+	Type         string      `json:"type"`
+	ExpressionID string      `json:"expression_id"`
+	Expression   *Expression `json:"expression,omitempty"`
+	Task         *TaskInfo   `json:"task,omitempty"`
+}
+
+const (
+	eventExpression = "expression"
+	eventTask       = "task"
+
+	subscriberBuffer  = 64
+	keepaliveInterval = 15 * time.Second
+)
+
+type subscriber struct {
+	expressionID string
+	events       chan Event
+}
+
+func (s *subscriber) final(event Event) bool {
+	return s.expressionID != "" && event.Type == eventExpression && event.Expression.Status != "pending"
+}
+
+type broker struct {
+	subscribers map[*subscriber]bool
+	closed      bool
+	mu          sync.Mutex
+}
+
+func newBroker() *broker {
+	return &broker{subscribers: make(map[*subscriber]bool)}
+}
+
+func (b *broker) subscribe(expressionID string) *subscriber {
+	sub := &subscriber{expressionID: expressionID, events: make(chan Event, subscriberBuffer)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(sub.events)
+		return sub
+	}
+	b.subscribers[sub] = true
+	return sub
+}
+
+func (b *broker) drop(expressionID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		if sub.expressionID == expressionID {
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (b *broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func (b *broker) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[sub] {
+		delete(b.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+func (b *broker) publish(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for sub := range b.subscribers {
+		if sub.expressionID != "" && sub.expressionID != event.ExpressionID {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// The client cannot keep up; drop it so that it reconnects and resyncs.
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (o *Orchestrator) publishExpression(expr *Expression) {
+	o.events.publish(Event{Type: eventExpression, ExpressionID: expr.ID, Expression: expr.withoutTasks()})
+}
+
+func (o *Orchestrator) publishTask(expr *Expression, info *TaskInfo) {
+	task := *info
+	o.events.publish(Event{Type: eventTask, ExpressionID: expr.ID, Task: &task})
+}
+
+func (o *Orchestrator) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	sub := o.events.subscribe("")
+	defer o.events.unsubscribe(sub)
+	stream(w, r, sub, nil)
+}
+
+func (o *Orchestrator) StreamExpressionEvents(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	o.mu.Lock()
+	expr, ok := o.expressions[id]
+	if !ok {
+		o.mu.Unlock()
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+	initial := []Event{{Type: eventExpression, ExpressionID: id, Expression: expr.withoutTasks()}}
+	sub := o.events.subscribe(id)
+	o.mu.Unlock()
+	defer o.events.unsubscribe(sub)
+	stream(w, r, sub, initial)
+}
+
+func stream(w http.ResponseWriter, r *http.Request, sub *subscriber, initial []Event) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	for _, event := range initial {
+		writeEvent(w, event)
+	}
+	flusher.Flush()
+	for _, event := range initial {
+		if sub.final(event) {
+			return
+		}
+	}
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case event, ok := <-sub.events:
+			if !ok {
+				return
+			}
+			writeEvent(w, event)
+			if sub.final(event) {
+				flusher.Flush()
+				return
+			}
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, event Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+}
@@ -0,0 +1,277 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Yorshik/final_task_sprint_1/internal/ast"
+	"github.com/gorilla/mux"
+)
+
+func newEventsServer(o *Orchestrator) *httptest.Server {
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/calculate", o.AddExpression).Methods("POST")
+	r.HandleFunc("/api/v1/expressions/{id}", o.DeleteExpression).Methods("DELETE")
+	r.HandleFunc("/api/v1/expressions/{id}/events", o.StreamExpressionEvents).Methods("GET")
+	r.HandleFunc("/api/v1/events", o.StreamEvents).Methods("GET")
+	return httptest.NewServer(r)
+}
+
+func readEvents(t *testing.T, resp *http.Response) <-chan Event {
+	t.Helper()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expected event stream, got %q", ct)
+	}
+	events := make(chan Event, 100)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		var name string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				var event Event
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil || event.Type != name {
+					t.Errorf("Malformed event %q: %v", line, err)
+					return
+				}
+				events <- event
+			}
+		}
+	}()
+	return events
+}
+
+func expectClosed(t *testing.T, events <-chan Event) {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Fatalf("Expected stream to end, got %+v", event)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for stream to end")
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Event stream closed")
+		}
+		return event
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for event")
+	}
+	return Event{}
+}
+
+func TestStreamExpressionEvents(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	s := newEventsServer(o)
+	defer s.Close()
+
+	node, _ := ast.Parse("2 * 3")
+	o.mu.Lock()
+	expr := o.newExpression("2 * 3", node, "", 0, o.lookupVariable)
+	o.mu.Unlock()
+
+	resp, err := http.Get(s.URL + "/api/v1/expressions/" + expr.ID + "/events")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	events := readEvents(t, resp)
+	if event := nextEvent(t, events); event.Type != eventExpression || event.Expression.Status != "pending" || event.Expression.StartedAt != nil {
+		t.Fatalf("Expected initial snapshot, got %+v", event)
+	}
+
+	go o.processExpression(expr)
+	task, ok := o.lease(<-o.tasks)
+	if !ok {
+		t.Fatal("Failed to lease task")
+	}
+	o.deliver(Result{ID: task.ID, Result: 6})
+
+	var statuses []string
+	for {
+		event := nextEvent(t, events)
+		if event.ExpressionID != expr.ID {
+			t.Errorf("Expected events for %s only, got %+v", expr.ID, event)
+		}
+		if event.Type == eventTask {
+			statuses = append(statuses, "task:"+event.Task.Status)
+			continue
+		}
+		statuses = append(statuses, event.Expression.Status)
+		if event.Expression.Status == "completed" {
+			if event.Expression.Result == nil || *event.Expression.Result != 6 {
+				t.Errorf("Expected final result 6, got %+v", event.Expression)
+			}
+			break
+		}
+	}
+	expected := "pending task:queued task:running task:completed completed"
+	if got := strings.Join(statuses, " "); got != expected {
+		t.Errorf("Expected events %q, got %q", expected, got)
+	}
+	expectClosed(t, events)
+}
+
+func TestStreamExpressionEventsFinished(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	s := newEventsServer(o)
+	defer s.Close()
+
+	node, _ := ast.Parse("2 * 3")
+	o.mu.Lock()
+	expr := o.newExpression("2 * 3", node, "", 0, o.lookupVariable)
+	expr.Status = "completed"
+	o.mu.Unlock()
+
+	resp, err := http.Get(s.URL + "/api/v1/expressions/" + expr.ID + "/events")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	events := readEvents(t, resp)
+	if event := nextEvent(t, events); event.Type != eventExpression || event.Expression.Status != "completed" {
+		t.Fatalf("Expected final snapshot, got %+v", event)
+	}
+	expectClosed(t, events)
+}
+
+func TestStreamExpressionEventsDelete(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	s := newEventsServer(o)
+	defer s.Close()
+
+	node, _ := ast.Parse("2 * 3")
+	o.mu.Lock()
+	expr := o.newExpression("2 * 3", node, "", 0, o.lookupVariable)
+	o.mu.Unlock()
+
+	resp, err := http.Get(s.URL + "/api/v1/expressions/" + expr.ID + "/events")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	events := readEvents(t, resp)
+	nextEvent(t, events)
+
+	del := func() {
+		req, _ := http.NewRequest("DELETE", s.URL+"/api/v1/expressions/"+expr.ID, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+	}
+	del()
+	if event := nextEvent(t, events); event.Type != eventExpression || event.Expression.Status != "cancelled" {
+		t.Fatalf("Expected cancellation event, got %+v", event)
+	}
+	expectClosed(t, events)
+
+	// A stream that is still open when the expression is removed must end as well.
+	sub := o.events.subscribe(expr.ID)
+	del()
+	select {
+	case _, ok := <-sub.events:
+		if ok {
+			t.Error("Expected no events after delete")
+		}
+	case <-time.After(1 * time.Second):
+		t.Error("Expected delete to close the subscription")
+	}
+}
+
+func TestStreamExpressionEventsNotFound(t *testing.T) {
+	o := NewOrchestrator()
+	s := newEventsServer(o)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/api/v1/expressions/missing/events")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	s := newEventsServer(o)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/api/v1/events")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	events := readEvents(t, resp)
+
+	var ids []string
+	for _, expression := range []string{"1 + 2", "y"} {
+		calc, err := http.Post(s.URL+"/api/v1/calculate", "application/json", bytes.NewBufferString(`{"expression": "`+expression+`"}`))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		var calcResp struct {
+			ID string `json:"id"`
+		}
+		json.NewDecoder(calc.Body).Decode(&calcResp)
+		calc.Body.Close()
+		ids = append(ids, calcResp.ID)
+	}
+
+	seen := make(map[string]string)
+	for len(seen) < 2 || seen[ids[1]] != "error" {
+		event := nextEvent(t, events)
+		if event.Type == eventExpression {
+			seen[event.ExpressionID] = event.Expression.Status
+		}
+	}
+	if _, ok := seen[ids[0]]; !ok {
+		t.Errorf("Expected events for %s, got %v", ids[0], seen)
+	}
+}
+
+func TestBrokerDropsSlowSubscriber(t *testing.T) {
+	b := newBroker()
+	slow := b.subscribe("")
+	other := b.subscribe("other")
+	for range subscriberBuffer + 1 {
+		b.publish(Event{Type: eventExpression, ExpressionID: "1"})
+	}
+
+	received := 0
+	for range slow.events {
+		received++
+	}
+	if received != subscriberBuffer {
+		t.Errorf("Expected %d buffered events before disconnect, got %d", subscriberBuffer, received)
+	}
+	if len(other.events) != 0 {
+		t.Errorf("Expected filtered subscriber to receive nothing, got %d events", len(other.events))
+	}
+	b.unsubscribe(slow)
+	b.unsubscribe(other)
+}
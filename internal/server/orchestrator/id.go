@@ -0,0 +1,55 @@
+package orchestrator
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+type idGenerator struct {
+	mu     sync.Mutex
+	last   int64
+	random [10]byte
+}
+
+func (g *idGenerator) next(now time.Time) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := now.UnixMilli()
+	if ms > g.last {
+		g.last = ms
+		rand.Read(g.random[:])
+	} else {
+		increment(g.random[:])
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(g.last)<<16)
+	copy(id[6:], g.random[:])
+	return encodeID(id)
+}
+
+func increment(b []byte) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+func encodeID(id [16]byte) string {
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	out := make([]byte, 26)
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
@@ -0,0 +1,45 @@
+package orchestrator
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIDGenerator(t *testing.T) {
+	var g idGenerator
+	now := time.Now()
+	ids := []string{
+		g.next(now),
+		g.next(now),
+		g.next(now.Add(-time.Second)),
+		g.next(now.Add(time.Millisecond)),
+	}
+
+	seen := make(map[string]bool)
+	for i, id := range ids {
+		if len(id) != 26 || strings.Trim(id, crockford) != "" {
+			t.Errorf("Expected 26 base32 characters, got %q", id)
+		}
+		if seen[id] {
+			t.Errorf("Duplicate ID %q", id)
+		}
+		seen[id] = true
+		if i > 0 && id <= ids[i-1] {
+			t.Errorf("Expected %q to sort after %q", id, ids[i-1])
+		}
+	}
+}
+
+func TestEncodeID(t *testing.T) {
+	var id [16]byte
+	if got := encodeID(id); got != strings.Repeat("0", 26) {
+		t.Errorf("Expected zero ID, got %q", got)
+	}
+	for i := range id {
+		id[i] = 0xff
+	}
+	if got := encodeID(id); got != "7"+strings.Repeat("Z", 25) {
+		t.Errorf("Expected max ID, got %q", got)
+	}
+}
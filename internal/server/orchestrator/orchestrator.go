@@ -1,12 +1,25 @@
 package orchestrator
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"math"
+	"math/big"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Yorshik/final_task_sprint_1/internal/ast"
@@ -14,117 +27,880 @@ import (
 )
 
 type Task struct {
-	ID            int     `json:"id"`
-	Arg1          float64 `json:"arg1"`
-	Arg2          float64 `json:"arg2"`
-	Operation     string  `json:"operation"`
-	OperationTime int     `json:"operation_time"`
+	ID            int       `json:"id"`
+	Arg1          float64   `json:"arg1"`
+	Arg2          float64   `json:"arg2"`
+	Args          []float64 `json:"args,omitempty"`
+	Operands      []string  `json:"operands,omitempty"`
+	Operation     string    `json:"operation"`
+	OperationTime int       `json:"operation_time"`
+	Mode          string    `json:"mode,omitempty"`
+	Precision     uint      `json:"precision,omitempty"`
+	Lease         string    `json:"lease,omitempty"`
+	expr          *Expression
+	node          string
+}
+
+type Result struct {
+	ID     int        `json:"id"`
+	Result float64    `json:"result"`
+	Value  string     `json:"value,omitempty"`
+	Mode   string     `json:"mode,omitempty"`
+	Lease  string     `json:"lease,omitempty"`
+	Error  *TaskError `json:"error,omitempty"`
+}
+
+type TaskError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *TaskError) Error() string {
+	return e.Message
+}
+
+type ExactResult struct {
+	Numerator   string `json:"numerator"`
+	Denominator string `json:"denominator"`
+	Decimal     string `json:"decimal"`
 }
 
 type Expression struct {
-	ID     string    `json:"id"`
-	Status string    `json:"status"`
-	Result *float64  `json:"result"`
-	Node   *ast.Node `json:"-"`
-	Tasks  []Task    `json:"-"`
+	ID             string       `json:"id"`
+	Expression     string       `json:"expression,omitempty"`
+	Status         string       `json:"status"`
+	Result         *float64     `json:"result"`
+	Mode           string       `json:"mode,omitempty"`
+	Precision      int          `json:"precision,omitempty"`
+	Exact          *ExactResult `json:"exact,omitempty"`
+	Value          string       `json:"value,omitempty"`
+	Error          string       `json:"error,omitempty"`
+	CreatedAt      time.Time    `json:"created_at"`
+	StartedAt      *time.Time   `json:"started_at,omitempty"`
+	FinishedAt     *time.Time   `json:"finished_at,omitempty"`
+	TaskCount      int          `json:"task_count"`
+	CompletedTasks int          `json:"completed_tasks"`
+	Node           *ast.Node    `json:"-"`
+	Tasks          []*TaskInfo  `json:"tasks,omitempty"`
+	results        map[string]Result
+	cancel         context.CancelFunc
+}
+
+type TaskInfo struct {
+	ID         int        `json:"id"`
+	Operation  string     `json:"operation"`
+	Status     string     `json:"status"`
+	Attempts   int        `json:"attempts"`
+	QueuedAt   time.Time  `json:"queued_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+type value struct {
+	float   float64
+	exact   *big.Rat
+	decimal *big.Float
+}
+
+const (
+	exactDecimalDigits = 20
+	maxPrecision       = 10000
+)
+
+var modeFunctions = map[string]map[string]bool{
+	"exact":   {"abs": true, "min": true, "max": true},
+	"decimal": {"abs": true, "min": true, "max": true, "sqrt": true},
+}
+
+type Template struct {
+	ID         string    `json:"id"`
+	Expression string    `json:"expression"`
+	Node       *ast.Node `json:"-"`
+}
+
+type pendingTask struct {
+	task     Task
+	info     *TaskInfo
+	done     chan Result
+	attempts int
+	lease    string
+	deadline time.Time
 }
 
+var (
+	errUnknownTask    = errors.New("unknown task")
+	errTaskCompleted  = errors.New("task already completed")
+	errLeaseExpired   = errors.New("task lease expired")
+	errNotLeaseHolder = errors.New("task is leased by another agent")
+)
+
 type Orchestrator struct {
 	expressions map[string]*Expression
+	order       []string
+	variables   map[string]float64
+	templates   map[string]*Template
 	tasks       chan Task
-	results     map[int]float64
+	pending     map[int]*pendingTask
 	taskID      int
+	taskIDLimit int
+	ids         idGenerator
+	leaseGrace  time.Duration
+	maxAttempts int
+	storage     Storage
+	events      *broker
+	draining    bool
 	mu          sync.Mutex
 	wg          sync.WaitGroup
 }
 
+const (
+	leaseCheckInterval = 500 * time.Millisecond
+	taskIDBlock        = 1000
+	defaultPageSize    = 50
+	maxPageSize        = 1000
+
+	serverShutdownTimeout = 5 * time.Second
+)
+
 func NewOrchestrator() *Orchestrator {
 	return &Orchestrator{
 		expressions: make(map[string]*Expression),
+		variables:   make(map[string]float64),
+		templates:   make(map[string]*Template),
 		tasks:       make(chan Task, 100),
-		results:     make(map[int]float64),
+		pending:     make(map[int]*pendingTask),
+		events:      newBroker(),
+		leaseGrace:  time.Duration(getEnvInt("LEASE_GRACE_MS", 5000)) * time.Millisecond,
+		maxAttempts: getEnvInt("MAX_TASK_ATTEMPTS", 3),
 	}
 }
 
+func NewOrchestratorWithStorage(storage Storage) (*Orchestrator, error) {
+	state, err := storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	o := NewOrchestrator()
+	o.storage = storage
+	o.taskID = state.TaskID
+	o.taskIDLimit = state.TaskID
+	for _, s := range state.Expressions {
+		s.Expression.results = s.Results
+		o.register(s.Expression)
+	}
+	for _, s := range state.Expressions {
+		o.startExpression(s.Expression)
+	}
+	return o, nil
+}
+
 func (o *Orchestrator) AddExpression(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Expression string `json:"expression"`
+		Mode       string `json:"mode"`
+		Precision  int    `json:"precision"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid data", http.StatusUnprocessableEntity)
 		return
 	}
+	mode, ok := parseMode(req.Mode, req.Precision)
+	if !ok {
+		http.Error(w, "Invalid mode", http.StatusUnprocessableEntity)
+		return
+	}
 
 	node, err := ast.Parse(req.Expression)
 	if err != nil {
+		writeParseError(w, err)
+		return
+	}
+	if !checkMode(w, node, mode) {
+		return
+	}
+
+	o.mu.Lock()
+	if o.draining {
+		o.mu.Unlock()
+		http.Error(w, "Orchestrator is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	expr := o.newExpression(req.Expression, node, mode, req.Precision, o.lookupVariable)
+	o.startExpression(expr)
+	o.mu.Unlock()
+	o.flush()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{"id": expr.ID})
+}
+
+func writeParseError(w http.ResponseWriter, err error) {
+	var syntaxErr *ast.SyntaxError
+	if !errors.As(err, &syntaxErr) {
 		http.Error(w, "Invalid expression", http.StatusUnprocessableEntity)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(struct {
+		Error    string `json:"error"`
+		Position int    `json:"position"`
+		Token    string `json:"token"`
+		Expected string `json:"expected"`
+	}{
+		Error:    syntaxErr.Error(),
+		Position: syntaxErr.Position,
+		Token:    syntaxErr.Token,
+		Expected: syntaxErr.Expected,
+	})
+}
 
+func parseMode(mode string, precision int) (string, bool) {
+	if precision < 0 || precision > maxPrecision {
+		return "", false
+	}
+	if precision > 0 {
+		return "decimal", mode == "" || mode == "decimal"
+	}
+	switch mode {
+	case "", "float":
+		return "", true
+	case "exact":
+		return mode, true
+	default:
+		return "", false
+	}
+}
+
+func precisionBits(digits int) uint {
+	return uint(math.Ceil(float64(digits)*math.Log2(10))) + 8
+}
+
+func checkMode(w http.ResponseWriter, node *ast.Node, mode string) bool {
+	functions, ok := modeFunctions[mode]
+	if !ok {
+		if hasOutOfRangeLiteral(node) {
+			http.Error(w, "Number is out of range in float mode, use exact or decimal mode", http.StatusUnprocessableEntity)
+			return false
+		}
+		return true
+	}
+	if name := unsupportedFunction(node, functions); name != "" {
+		http.Error(w, fmt.Sprintf("Function %s is not supported in %s mode", name, mode), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
+
+func hasOutOfRangeLiteral(node *ast.Node) bool {
+	if node == nil {
+		return false
+	}
+	if node.IsNumber() && node.Exact != nil {
+		if value, _ := node.Exact.Float64(); math.IsInf(value, 0) {
+			return true
+		}
+	}
+	for _, child := range append([]*ast.Node{node.Left, node.Right}, node.Args...) {
+		if hasOutOfRangeLiteral(child) {
+			return true
+		}
+	}
+	return false
+}
+
+func unsupportedFunction(node *ast.Node, functions map[string]bool) string {
+	if node == nil {
+		return ""
+	}
+	if node.Function != "" && !functions[node.Function] {
+		return node.Function
+	}
+	for _, child := range append([]*ast.Node{node.Left, node.Right}, node.Args...) {
+		if name := unsupportedFunction(child, functions); name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func (o *Orchestrator) newExpression(text string, node *ast.Node, mode string, precision int, lookup func(name string) (float64, bool)) *Expression {
+	now := time.Now()
+	expr := &Expression{
+		ID:         o.ids.next(now),
+		Expression: text,
+		Status:     "pending",
+		Mode:       mode,
+		Precision:  precision,
+		CreatedAt:  now,
+		Node:       node,
+	}
+	o.register(expr)
+	if err := node.Resolve(lookup); err != nil {
+		expr.Status = "error"
+		expr.Error = err.Error()
+		expr.FinishedAt = &now
+	} else {
+		expr.TaskCount = countTasks(node)
+	}
+	o.saveExpression(expr)
+	o.publishExpression(expr)
+	return expr
+}
+
+func countTasks(node *ast.Node) int {
+	if node.IsNumber() || node.Operator == "neg" && node.Left.IsNumber() {
+		return 0
+	}
+	count := 1
+	for _, child := range append([]*ast.Node{node.Left, node.Right}, node.Args...) {
+		if child != nil {
+			count += countTasks(child)
+		}
+	}
+	return count
+}
+
+func (o *Orchestrator) register(expr *Expression) {
+	o.expressions[expr.ID] = expr
+	if i, found := slices.BinarySearch(o.order, expr.ID); !found {
+		o.order = slices.Insert(o.order, i, expr.ID)
+	}
+}
+
+func (o *Orchestrator) unregister(id string) {
+	delete(o.expressions, id)
+	if i, found := slices.BinarySearch(o.order, id); found {
+		o.order = slices.Delete(o.order, i, i+1)
+	}
+}
+
+func (o *Orchestrator) saveExpression(expr *Expression) {
+	if o.storage == nil {
+		return
+	}
+	if err := o.storage.SaveExpression(expr); err != nil {
+		log.Printf("Failed to persist expression %s: %v", expr.ID, err)
+	}
+}
+
+func (o *Orchestrator) flush() {
 	o.mu.Lock()
-	id := strconv.Itoa(len(o.expressions) + 1)
-	expr := &Expression{ID: id, Status: "pending", Node: node}
-	o.expressions[id] = expr
+	storage := o.storage
 	o.mu.Unlock()
+	if storage == nil {
+		return
+	}
+	if err := storage.Flush(); err != nil {
+		log.Printf("Failed to persist state: %v", err)
+	}
+}
+
+func (o *Orchestrator) saveResult(task Task, result Result, info *TaskInfo) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.storage == nil {
+		return
+	}
+	snapshot := *info
+	if err := o.storage.SaveResult(task.expr.ID, task.node, result, &snapshot); err != nil {
+		log.Printf("Failed to persist result of task %d: %v", task.ID, err)
+	}
+}
+
+func (o *Orchestrator) startExpression(expr *Expression) {
+	if expr.Status != "pending" {
+		return
+	}
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		o.processExpression(expr)
+	}()
+}
+
+func (o *Orchestrator) Drain(ctx context.Context) error {
+	o.mu.Lock()
+	o.draining = true
+	o.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		o.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (o *Orchestrator) Close() {
+	o.mu.Lock()
+	o.storage = nil
+	o.mu.Unlock()
+	o.events.close()
+}
+
+func (o *Orchestrator) AddTemplate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Expression string `json:"expression"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid data", http.StatusUnprocessableEntity)
+		return
+	}
 
-	go o.processExpression(expr)
+	node, err := ast.Parse(req.Expression)
+	if err != nil {
+		writeParseError(w, err)
+		return
+	}
+
+	o.mu.Lock()
+	id := o.ids.next(time.Now())
+	o.templates[id] = &Template{ID: id, Expression: req.Expression, Node: node}
+	o.mu.Unlock()
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(map[string]string{"id": id})
 }
 
+func (o *Orchestrator) EvaluateTemplate(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var req struct {
+		Bindings  []map[string]float64 `json:"bindings"`
+		Mode      string               `json:"mode"`
+		Precision int                  `json:"precision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Bindings) == 0 {
+		http.Error(w, "Invalid data", http.StatusUnprocessableEntity)
+		return
+	}
+	mode, ok := parseMode(req.Mode, req.Precision)
+	if !ok {
+		http.Error(w, "Invalid mode", http.StatusUnprocessableEntity)
+		return
+	}
+
+	o.mu.Lock()
+	if o.draining {
+		o.mu.Unlock()
+		http.Error(w, "Orchestrator is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	tmpl, ok := o.templates[id]
+	if !ok {
+		o.mu.Unlock()
+		http.Error(w, "Template not found", http.StatusNotFound)
+		return
+	}
+	if !checkMode(w, tmpl.Node, mode) {
+		o.mu.Unlock()
+		return
+	}
+	ids := make([]string, len(req.Bindings))
+	for i, binding := range req.Bindings {
+		expr := o.newExpression(tmpl.Expression, tmpl.Node.Clone(), mode, req.Precision, func(name string) (float64, bool) {
+			if value, ok := binding[name]; ok {
+				return value, true
+			}
+			return o.lookupVariable(name)
+		})
+		o.startExpression(expr)
+		ids[i] = expr.ID
+	}
+	o.mu.Unlock()
+	o.flush()
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string][]string{"ids": ids})
+}
+
+func (o *Orchestrator) lookupVariable(name string) (float64, bool) {
+	value, ok := o.variables[name]
+	return value, ok
+}
+
+func (o *Orchestrator) SetVariable(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if !ast.IsIdentifier(name) {
+		http.Error(w, "Invalid variable name", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var req struct {
+		Value *float64 `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Value == nil {
+		http.Error(w, "Invalid data", http.StatusUnprocessableEntity)
+		return
+	}
+
+	o.mu.Lock()
+	o.variables[name] = *req.Value
+	o.mu.Unlock()
+
+	json.NewEncoder(w).Encode(struct {
+		Name  string  `json:"name"`
+		Value float64 `json:"value"`
+	}{Name: name, Value: *req.Value})
+}
+
 func (o *Orchestrator) processExpression(expr *Expression) {
-	result := o.evaluateNode(expr.Node, expr)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	o.mu.Lock()
+	if expr.Status != "pending" {
+		o.mu.Unlock()
+		return
+	}
+	expr.cancel = cancel
+	if expr.StartedAt == nil {
+		now := time.Now()
+		expr.StartedAt = &now
+		o.saveExpression(expr)
+	}
+	expr.CompletedTasks = 0
+	o.publishExpression(expr)
+	o.mu.Unlock()
+	result, err := o.evaluateNode(ctx, expr.Node, "0", expr)
 
 	o.mu.Lock()
-	expr.Result = &result
+	defer o.mu.Unlock()
+	if expr.Status != "pending" {
+		return
+	}
+	defer o.saveExpression(expr)
+	defer o.publishExpression(expr)
+	now := time.Now()
+	expr.FinishedAt = &now
+	if err != nil {
+		expr.Status = "error"
+		expr.Error = err.Error()
+		return
+	}
+	approx := result.float
+	switch {
+	case expr.Mode == "exact" && result.exact != nil:
+		approx, _ = result.exact.Float64()
+		expr.Exact = newExactResult(result.exact)
+	case expr.Mode == "decimal" && result.decimal != nil:
+		approx, _ = result.decimal.Float64()
+		expr.Value = result.decimal.Text('g', expr.Precision)
+	}
+	if !math.IsInf(approx, 0) {
+		expr.Result = &approx
+	}
 	expr.Status = "completed"
-	o.mu.Unlock()
 }
 
-func (o *Orchestrator) evaluateNode(node *ast.Node, expr *Expression) float64 {
-	if node.Operator == "" {
-		return node.Value
+func newExactResult(x *big.Rat) *ExactResult {
+	decimal := x.FloatString(exactDecimalDigits)
+	decimal = strings.TrimRight(strings.TrimRight(decimal, "0"), ".")
+	if decimal == "-0" {
+		decimal = "0"
+	}
+	return &ExactResult{
+		Numerator:   x.Num().String(),
+		Denominator: x.Denom().String(),
+		Decimal:     decimal,
 	}
+}
 
-	var leftVal, rightVal float64
-	if node.Left.Operator != "" {
-		leftVal = o.evaluateNode(node.Left, expr)
-	} else {
-		leftVal = node.Left.Value
+func (o *Orchestrator) evaluateNode(ctx context.Context, node *ast.Node, path string, expr *Expression) (value, error) {
+	if node.IsNumber() {
+		return numberValue(node, expr), nil
 	}
-	if node.Right.Operator != "" {
-		rightVal = o.evaluateNode(node.Right, expr)
-	} else {
-		rightVal = node.Right.Value
+	if node.Operator == "neg" && node.Left.IsNumber() {
+		return numberValue(node.Left, expr).neg(), nil
 	}
+	if result, ok := expr.results[path]; ok {
+		o.mu.Lock()
+		expr.CompletedTasks += countTasks(node)
+		o.mu.Unlock()
+		return restoredValue(result, expr)
+	}
+
+	operation := node.Operator
+	children := []*ast.Node{node.Left}
+	if node.Right != nil {
+		children = append(children, node.Right)
+	}
+	if node.Function != "" {
+		operation = node.Function
+		children = node.Args
+	}
+	operands, err := o.evaluateChildren(ctx, children, path, expr)
+	if err != nil {
+		return value{}, err
+	}
+	task := o.newTask(operation, operands, expr)
+	task.node = path
+	return o.dispatch(ctx, task, expr)
+}
+
+func restoredValue(result Result, expr *Expression) (value, error) {
+	task := Task{ID: result.ID, Mode: expr.Mode}
+	if expr.Mode == "decimal" {
+		task.Precision = precisionBits(expr.Precision)
+	}
+	return resultValue(result, task)
+}
+
+func (o *Orchestrator) evaluateChildren(ctx context.Context, children []*ast.Node, path string, expr *Expression) ([]value, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	operands := make([]value, len(children))
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i, child := range children {
+		if child.IsNumber() {
+			operands[i] = numberValue(child, expr)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			operand, err := o.evaluateNode(ctx, child, path+"."+strconv.Itoa(i), expr)
+			if err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			operands[i] = operand
+		}()
+	}
+	wg.Wait()
+	return operands, firstErr
+}
+
+func numberValue(node *ast.Node, expr *Expression) value {
+	v := value{float: node.Value, exact: node.Exact}
+	if expr.Mode == "decimal" && node.Exact != nil {
+		v.decimal = new(big.Float).SetPrec(precisionBits(expr.Precision)).SetRat(node.Exact)
+	}
+	return v
+}
+
+func (v value) neg() value {
+	result := value{float: -v.float}
+	if v.exact != nil {
+		result.exact = new(big.Rat).Neg(v.exact)
+	}
+	if v.decimal != nil {
+		result.decimal = new(big.Float).Neg(v.decimal)
+	}
+	return result
+}
+
+func (o *Orchestrator) newTask(operation string, operands []value, expr *Expression) Task {
+	task := Task{
+		Operation:     operation,
+		OperationTime: o.getOperationTime(operation),
+		Mode:          expr.Mode,
+		expr:          expr,
+	}
+	switch {
+	case expr.Mode == "exact":
+		for _, operand := range operands {
+			task.Operands = append(task.Operands, operand.exact.RatString())
+		}
+	case expr.Mode == "decimal":
+		task.Precision = precisionBits(expr.Precision)
+		for _, operand := range operands {
+			task.Operands = append(task.Operands, operand.decimal.Text('g', -1))
+		}
+	case ast.IsFunction(operation):
+		for _, operand := range operands {
+			task.Args = append(task.Args, operand.float)
+		}
+	default:
+		task.Arg1 = operands[0].float
+		if len(operands) > 1 {
+			task.Arg2 = operands[1].float
+		}
+	}
+	return task
+}
 
+func (o *Orchestrator) dispatch(ctx context.Context, task Task, expr *Expression) (value, error) {
+	done := make(chan Result, 1)
 	o.mu.Lock()
+	task.ID = o.nextTaskID()
+	info := &TaskInfo{ID: task.ID, Operation: task.Operation, Status: "queued", QueuedAt: time.Now()}
+	expr.Tasks = append(expr.Tasks, info)
+	o.pending[task.ID] = &pendingTask{task: task, info: info, done: done}
+	o.publishTask(expr, info)
+	o.mu.Unlock()
+	defer o.forget(task.ID)
+
+	select {
+	case o.tasks <- task:
+	case <-ctx.Done():
+		o.finishTask(expr, info, ctx.Err())
+		return value{}, ctx.Err()
+	}
+
+	select {
+	case result := <-done:
+		v, err := resultValue(result, task)
+		o.finishTask(expr, info, err)
+		if err == nil {
+			o.saveResult(task, result, info)
+		}
+		return v, err
+	case <-ctx.Done():
+		o.finishTask(expr, info, ctx.Err())
+		return value{}, ctx.Err()
+	}
+}
+
+func (o *Orchestrator) finishTask(expr *Expression, info *TaskInfo, err error) {
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	info.FinishedAt = &now
+	switch {
+	case err == nil:
+		info.Status = "completed"
+		expr.CompletedTasks++
+	case errors.Is(err, context.Canceled):
+		info.Status = "cancelled"
+	default:
+		info.Status = "failed"
+		info.Error = err.Error()
+	}
+	o.publishTask(expr, info)
+}
+
+func (o *Orchestrator) nextTaskID() int {
 	o.taskID++
-	task := Task{
-		ID:            o.taskID,
-		Arg1:          leftVal,
-		Arg2:          rightVal,
-		Operation:     node.Operator,
-		OperationTime: o.getOperationTime(node.Operator),
+	if o.storage != nil && o.taskID > o.taskIDLimit {
+		o.taskIDLimit = o.taskID + taskIDBlock - 1
+		if err := o.storage.SaveTaskID(o.taskIDLimit); err != nil {
+			log.Printf("Failed to persist task ID %d: %v", o.taskIDLimit, err)
+		}
 	}
-	expr.Tasks = append(expr.Tasks, task)
+	return o.taskID
+}
+
+func (o *Orchestrator) forget(taskID int) {
+	o.mu.Lock()
+	delete(o.pending, taskID)
 	o.mu.Unlock()
+}
 
-	o.tasks <- task
+func (o *Orchestrator) accept(result Result) error {
+	o.mu.Lock()
+	p, err := o.leaseHolder(result.ID, result.Lease)
+	if err == nil {
+		delete(o.pending, result.ID)
+	}
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	p.done <- result
+	return nil
+}
 
+func (o *Orchestrator) leaseHolder(id int, lease string) (*pendingTask, error) {
+	p, ok := o.pending[id]
+	switch {
+	case !ok && (id <= 0 || id > o.taskID):
+		return nil, errUnknownTask
+	case !ok:
+		return nil, errTaskCompleted
+	case p.lease == "":
+		return nil, errLeaseExpired
+	case p.lease != lease:
+		return nil, errNotLeaseHolder
+	default:
+		return p, nil
+	}
+}
+
+func (o *Orchestrator) WatchLeases(ctx context.Context) {
+	ticker := time.NewTicker(leaseCheckInterval)
+	defer ticker.Stop()
 	for {
-		o.mu.Lock()
-		if result, ok := o.results[task.ID]; ok {
-			delete(o.results, task.ID)
-			o.mu.Unlock()
-			return result
+		select {
+		case now := <-ticker.C:
+			o.expireLeases(now)
+		case <-ctx.Done():
+			return
 		}
-		o.mu.Unlock()
-		time.Sleep(100 * time.Millisecond)
 	}
 }
 
+func (o *Orchestrator) expireLeases(now time.Time) {
+	var (
+		requeue []Task
+		failed  []*pendingTask
+	)
+	o.mu.Lock()
+	for id, p := range o.pending {
+		if p.deadline.IsZero() || now.Before(p.deadline) {
+			continue
+		}
+		o.revokeLease(p)
+		if p.attempts >= o.maxAttempts {
+			delete(o.pending, id)
+			failed = append(failed, p)
+			continue
+		}
+		requeue = append(requeue, p.task)
+	}
+	o.mu.Unlock()
+
+	for _, p := range failed {
+		p.done <- Result{ID: p.task.ID, Error: &TaskError{
+			Code:    "lease_expired",
+			Message: fmt.Sprintf("task %d was not completed after %d attempts", p.task.ID, p.attempts),
+		}}
+	}
+	for _, task := range requeue {
+		o.tasks <- task
+	}
+}
+
+func (o *Orchestrator) revokeLease(p *pendingTask) {
+	p.deadline = time.Time{}
+	p.lease = ""
+	p.info.Status = "queued"
+	o.publishTask(p.task.expr, p.info)
+}
+
+func resultValue(result Result, task Task) (value, error) {
+	if result.Error != nil {
+		return value{}, result.Error
+	}
+	v := value{float: result.Result}
+	var ok bool
+	switch task.Mode {
+	case "exact":
+		v.exact, ok = new(big.Rat).SetString(result.Value)
+	case "decimal":
+		v.decimal, ok = new(big.Float).SetPrec(task.Precision).SetString(result.Value)
+	default:
+		ok = true
+	}
+	if !ok || result.Mode != task.Mode {
+		return value{}, &TaskError{Code: "invalid_result", Message: fmt.Sprintf("invalid result for task %d", task.ID)}
+	}
+	return v, nil
+}
+
 func (o *Orchestrator) getOperationTime(op string) int {
 	switch op {
 	case "+":
@@ -135,7 +911,14 @@ func (o *Orchestrator) getOperationTime(op string) int {
 		return getEnvInt("TIME_MULTIPLICATIONS_MS", 1000)
 	case "/":
 		return getEnvInt("TIME_DIVISIONS_MS", 1000)
+	case "^":
+		return getEnvInt("TIME_POWER_MS", 1000)
+	case "neg":
+		return getEnvInt("TIME_NEGATION_MS", 1000)
 	default:
+		if ast.IsFunction(op) {
+			return getEnvInt("TIME_FUNCTIONS_MS", 1000)
+		}
 		return 1000
 	}
 }
@@ -149,15 +932,105 @@ func getEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+type expressionQuery struct {
+	statuses map[string]bool
+	after    time.Time
+	before   time.Time
+	desc     bool
+	cursor   string
+	limit    int
+}
+
+func parseExpressionQuery(values url.Values) (expressionQuery, error) {
+	query := expressionQuery{limit: defaultPageSize}
+	if status := values.Get("status"); status != "" {
+		query.statuses = make(map[string]bool)
+		for _, s := range strings.Split(status, ",") {
+			query.statuses[strings.TrimSpace(s)] = true
+		}
+	}
+	var err error
+	if after := values.Get("created_after"); after != "" {
+		if query.after, err = time.Parse(time.RFC3339, after); err != nil {
+			return query, err
+		}
+	}
+	if before := values.Get("created_before"); before != "" {
+		if query.before, err = time.Parse(time.RFC3339, before); err != nil {
+			return query, err
+		}
+	}
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.desc = true
+	default:
+		return query, errors.New("invalid order")
+	}
+	if cursor := values.Get("cursor"); cursor != "" {
+		id, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil || len(id) == 0 {
+			return query, errors.New("invalid cursor")
+		}
+		query.cursor = string(id)
+	}
+	if limit := values.Get("limit"); limit != "" {
+		query.limit, err = strconv.Atoi(limit)
+		if err != nil || query.limit <= 0 || query.limit > maxPageSize {
+			return query, errors.New("invalid limit")
+		}
+	}
+	return query, nil
+}
+
+func (q expressionQuery) matches(expr *Expression) bool {
+	if q.statuses != nil && !q.statuses[expr.Status] {
+		return false
+	}
+	if !q.after.IsZero() && expr.CreatedAt.Before(q.after) {
+		return false
+	}
+	return q.before.IsZero() || expr.CreatedAt.Before(q.before)
+}
+
 func (o *Orchestrator) GetExpressions(w http.ResponseWriter, r *http.Request) {
+	query, err := parseExpressionQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, "Invalid query", http.StatusUnprocessableEntity)
+		return
+	}
+
 	o.mu.Lock()
 	defer o.mu.Unlock()
 
 	resp := struct {
 		Expressions []*Expression `json:"expressions"`
-	}{Expressions: make([]*Expression, 0, len(o.expressions))}
-	for _, expr := range o.expressions {
-		resp.Expressions = append(resp.Expressions, expr)
+		NextCursor  string        `json:"next_cursor,omitempty"`
+	}{Expressions: make([]*Expression, 0, min(query.limit, len(o.order)))}
+
+	i, found := slices.BinarySearch(o.order, query.cursor)
+	step := 1
+	switch {
+	case query.desc && query.cursor == "":
+		i = len(o.order) - 1
+		step = -1
+	case query.desc:
+		i--
+		step = -1
+	case found:
+		i++
+	}
+	for ; i >= 0 && i < len(o.order); i += step {
+		expr := o.expressions[o.order[i]]
+		if !query.matches(expr) {
+			continue
+		}
+		if len(resp.Expressions) == query.limit {
+			last := resp.Expressions[len(resp.Expressions)-1]
+			resp.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(last.ID))
+			break
+		}
+		resp.Expressions = append(resp.Expressions, expr.withoutTasks())
 	}
 	json.NewEncoder(w).Encode(resp)
 }
@@ -172,31 +1045,176 @@ func (o *Orchestrator) GetExpression(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Expression not found", http.StatusNotFound)
 		return
 	}
+	if !slices.Contains(strings.Split(r.URL.Query().Get("include"), ","), "tasks") {
+		expr = expr.withoutTasks()
+	}
 	json.NewEncoder(w).Encode(map[string]*Expression{"expression": expr})
 }
 
+func (e *Expression) withoutTasks() *Expression {
+	view := *e
+	view.Tasks = nil
+	return &view
+}
+
+func (o *Orchestrator) DeleteExpression(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	o.mu.Lock()
+	expr, ok := o.expressions[id]
+	if !ok {
+		o.mu.Unlock()
+		http.Error(w, "Expression not found", http.StatusNotFound)
+		return
+	}
+	if expr.Status != "pending" {
+		o.unregister(id)
+		o.deleteExpression(id)
+		o.events.drop(id)
+		o.mu.Unlock()
+		o.flush()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	now := time.Now()
+	expr.Status = "cancelled"
+	expr.FinishedAt = &now
+	if expr.cancel != nil {
+		expr.cancel()
+	}
+	for taskID, p := range o.pending {
+		if p.task.expr == expr {
+			delete(o.pending, taskID)
+		}
+	}
+	o.saveExpression(expr)
+	o.publishExpression(expr)
+	body, err := json.Marshal(map[string]*Expression{"expression": expr})
+	o.mu.Unlock()
+	o.flush()
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(append(body, '\n'))
+}
+
+func (o *Orchestrator) deleteExpression(id string) {
+	if o.storage == nil {
+		return
+	}
+	if err := o.storage.DeleteExpression(id); err != nil {
+		log.Printf("Failed to delete expression %s: %v", id, err)
+	}
+}
+
 func (o *Orchestrator) GetTask(w http.ResponseWriter, r *http.Request) {
-	select {
-	case task := <-o.tasks:
-		json.NewEncoder(w).Encode(map[string]Task{"task": task})
+	for {
+		select {
+		case task := <-o.tasks:
+			task, ok := o.lease(task)
+			if !ok {
+				continue
+			}
+			json.NewEncoder(w).Encode(map[string]Task{"task": task})
+		default:
+			http.Error(w, "No tasks available", http.StatusNotFound)
+		}
+		return
+	}
+}
+
+func (o *Orchestrator) lease(task Task) (Task, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	p, ok := o.pending[task.ID]
+	if !ok || p.lease != "" {
+		return task, false
+	}
+	now := time.Now()
+	p.attempts++
+	p.lease = newLeaseToken()
+	p.deadline = now.Add(time.Duration(task.OperationTime)*time.Millisecond + o.leaseGrace)
+	p.info.Status = "running"
+	p.info.Attempts = p.attempts
+	p.info.StartedAt = &now
+	o.publishTask(task.expr, p.info)
+	task.Lease = p.lease
+	return task, true
+}
+
+func newLeaseToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (o *Orchestrator) CheckTask(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	o.mu.Lock()
+	_, err = o.leaseHolder(id, r.URL.Query().Get("lease"))
+	o.mu.Unlock()
+
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusOK)
+	case errUnknownTask:
+		http.Error(w, "Task not found", http.StatusNotFound)
 	default:
-		http.Error(w, "No tasks available", http.StatusNotFound)
+		http.Error(w, "Task is no longer awaiting a result", http.StatusGone)
 	}
 }
 
-func (o *Orchestrator) ReceiveResult(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		ID     int     `json:"id"`
-		Result float64 `json:"result"`
+func (o *Orchestrator) ReleaseTask(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	o.mu.Lock()
+	p, err := o.leaseHolder(id, r.URL.Query().Get("lease"))
+	if err == nil {
+		p.attempts--
+		o.revokeLease(p)
+	}
+	o.mu.Unlock()
+
+	switch err {
+	case nil:
+		o.tasks <- p.task
+		w.WriteHeader(http.StatusNoContent)
+	case errUnknownTask:
+		http.Error(w, "Task not found", http.StatusNotFound)
+	case errNotLeaseHolder:
+		http.Error(w, "Task is leased by another agent", http.StatusForbidden)
+	default:
+		http.Error(w, "Task is no longer awaiting a result", http.StatusConflict)
 	}
+}
+
+func (o *Orchestrator) ReceiveResult(w http.ResponseWriter, r *http.Request) {
+	var req Result
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid data", http.StatusUnprocessableEntity)
 		return
 	}
 
-	o.mu.Lock()
-	o.results[req.ID] = req.Result
-	o.mu.Unlock()
+	switch err := o.accept(req); err {
+	case nil:
+	case errUnknownTask:
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	case errNotLeaseHolder:
+		http.Error(w, "Task is leased by another agent", http.StatusForbidden)
+		return
+	default:
+		http.Error(w, "Task is no longer awaiting a result", http.StatusConflict)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -205,19 +1223,75 @@ func (o *Orchestrator) Web(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "templates/index.html")
 }
 
-func StartServer() {
-	o := NewOrchestrator()
+func (o *Orchestrator) Router() http.Handler {
 	r := mux.NewRouter()
 
 	r.HandleFunc("/api/v1/calculate", o.AddExpression).Methods("POST")
 	r.HandleFunc("/api/v1/expressions", o.GetExpressions).Methods("GET")
 	r.HandleFunc("/api/v1/expressions/{id}", o.GetExpression).Methods("GET")
+	r.HandleFunc("/api/v1/expressions/{id}", o.DeleteExpression).Methods("DELETE")
+	r.HandleFunc("/api/v1/expressions/{id}/events", o.StreamExpressionEvents).Methods("GET")
+	r.HandleFunc("/api/v1/events", o.StreamEvents).Methods("GET")
+	r.HandleFunc("/api/v1/variables/{name}", o.SetVariable).Methods("PUT")
+	r.HandleFunc("/api/v1/templates", o.AddTemplate).Methods("POST")
+	r.HandleFunc("/api/v1/templates/{id}/evaluate", o.EvaluateTemplate).Methods("POST")
 	r.HandleFunc("/internal/task", o.GetTask).Methods("GET")
 	r.HandleFunc("/internal/task", o.ReceiveResult).Methods("POST")
+	r.HandleFunc("/internal/task/{id}", o.CheckTask).Methods("GET")
+	r.HandleFunc("/internal/task/{id}", o.ReleaseTask).Methods("DELETE")
 	r.HandleFunc("/", o.Web).Methods("GET")
+	return r
+}
 
-	err := http.ListenAndServe(":8080", r)
-	if err != nil {
-		fmt.Println(err)
+func StartServer(cfg Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return Run(ctx, cfg)
+}
+
+func Run(ctx context.Context, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	o := NewOrchestrator()
+	if cfg.StoragePath != "" {
+		storage, err := OpenFileStorage(cfg.StoragePath)
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+		if o, err = NewOrchestratorWithStorage(storage); err != nil {
+			return err
+		}
+	}
+	leaseCtx, stopLeases := context.WithCancel(context.Background())
+	defer stopLeases()
+	go o.WatchLeases(leaseCtx)
+
+	srv := &http.Server{Addr: cfg.Addr, Handler: o.Router()}
+	errs := make(chan error, 1)
+	go func() {
+		if cfg.TLSCertFile != "" {
+			errs <- srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			errs <- srv.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
 	}
+
+	log.Println("Shutting down, waiting for in-flight expressions...")
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := o.Drain(drainCtx); err != nil {
+		log.Println("Shutdown timeout exceeded, unfinished expressions will resume on the next start")
+	}
+	o.Close()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
 }
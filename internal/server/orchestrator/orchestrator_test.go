@@ -2,10 +2,17 @@ package orchestrator
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -18,6 +25,19 @@ func setupEnv() {
 	os.Setenv("TIME_SUBTRACTION_MS", "100")
 	os.Setenv("TIME_MULTIPLICATIONS_MS", "100")
 	os.Setenv("TIME_DIVISIONS_MS", "100")
+	os.Setenv("TIME_POWER_MS", "100")
+	os.Setenv("TIME_FUNCTIONS_MS", "100")
+}
+
+func (o *Orchestrator) deliver(result Result) bool {
+	o.mu.Lock()
+	p, ok := o.pending[result.ID]
+	delete(o.pending, result.ID)
+	o.mu.Unlock()
+	if ok {
+		p.done <- result
+	}
+	return ok
 }
 
 func TestAddExpression(t *testing.T) {
@@ -32,6 +52,10 @@ func TestAddExpression(t *testing.T) {
 		expectedCode int
 	}{
 		{"ValidExpression", "2 + 3", http.StatusCreated},
+		{"UnaryMinus", "-3 + 5", http.StatusCreated},
+		{"Power", "2 ^ 3 ** 2", http.StatusCreated},
+		{"FunctionCall", "sqrt(16) + max(1, 2, 3)", http.StatusCreated},
+		{"UnknownFunction", "foo(1)", http.StatusUnprocessableEntity},
 		{"InvalidExpression", "2 + * 3", http.StatusUnprocessableEntity},
 		{"EmptyExpression", "", http.StatusUnprocessableEntity},
 	}
@@ -67,7 +91,7 @@ func TestGetExpressions(t *testing.T) {
 	o := NewOrchestrator()
 	node, _ := ast.Parse("2 + 3")
 	o.mu.Lock()
-	o.expressions["1"] = &Expression{ID: "1", Status: "pending", Node: node}
+	o.register(&Expression{ID: "1", Status: "pending", Node: node})
 	o.mu.Unlock()
 	r := httptest.NewServer(http.HandlerFunc(o.GetExpressions))
 	defer r.Close()
@@ -93,6 +117,117 @@ func TestGetExpressions(t *testing.T) {
 	}
 }
 
+func TestGetExpressionsOrder(t *testing.T) {
+	o := NewOrchestrator()
+	var ids []string
+	for range 5 {
+		node, _ := ast.Parse("1 + 2")
+		ids = append(ids, o.newExpression("", node, "", 0, o.lookupVariable).ID)
+	}
+	r := httptest.NewServer(http.HandlerFunc(o.GetExpressions))
+	defer r.Close()
+
+	resp, err := http.Get(r.URL)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	var respData struct {
+		Expressions []Expression `json:"expressions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(respData.Expressions) != len(ids) {
+		t.Fatalf("Expected %d expressions, got %d", len(ids), len(respData.Expressions))
+	}
+	for i, expr := range respData.Expressions {
+		if expr.ID != ids[i] {
+			t.Errorf("Expected expression %d to be %s, got %s", i, ids[i], expr.ID)
+		}
+	}
+}
+
+func TestGetExpressionsPagination(t *testing.T) {
+	o := NewOrchestrator()
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	statuses := []string{"completed", "pending", "error", "completed", "cancelled"}
+	var ids []string
+	for i, status := range statuses {
+		id := fmt.Sprintf("%02d", i)
+		o.register(&Expression{ID: id, Status: status, CreatedAt: base.Add(time.Duration(i) * time.Hour)})
+		ids = append(ids, id)
+	}
+	r := httptest.NewServer(http.HandlerFunc(o.GetExpressions))
+	defer r.Close()
+
+	fetch := func(query string) ([]string, string, int) {
+		resp, err := http.Get(r.URL + "?" + query)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+		var respData struct {
+			Expressions []Expression `json:"expressions"`
+			NextCursor  string       `json:"next_cursor"`
+		}
+		json.NewDecoder(resp.Body).Decode(&respData)
+		var got []string
+		for _, expr := range respData.Expressions {
+			got = append(got, expr.ID)
+		}
+		return got, respData.NextCursor, resp.StatusCode
+	}
+	collect := func(query string) []string {
+		var all []string
+		cursor := ""
+		for range len(ids) + 1 {
+			page, next, code := fetch(query + "&cursor=" + cursor)
+			if code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", code)
+			}
+			all = append(all, page...)
+			if next == "" {
+				return all
+			}
+			cursor = next
+		}
+		t.Fatalf("Pagination did not terminate for %q", query)
+		return nil
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"Ascending", "limit=2", ids},
+		{"Descending", "limit=2&order=desc", []string{"04", "03", "02", "01", "00"}},
+		{"Status", "limit=1&status=completed,error", []string{"00", "02", "03"}},
+		{"CreatedRange", "limit=2&created_after=2024-01-01T01:00:00Z&created_before=2024-01-01T04:00:00Z", []string{"01", "02", "03"}},
+		{"NoMatches", "status=unknown", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collect(tt.query)
+			if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	page, next, _ := fetch("limit=5")
+	if len(page) != 5 || next != "" {
+		t.Errorf("Expected a single full page without cursor, got %v and %q", page, next)
+	}
+
+	for _, query := range []string{"limit=0", "limit=abc", "limit=100000", "order=sideways", "cursor=!!!", "created_after=yesterday"} {
+		if _, _, code := fetch(query); code != http.StatusUnprocessableEntity {
+			t.Errorf("Expected status 422 for %q, got %d", query, code)
+		}
+	}
+}
+
 func TestGetExpression(t *testing.T) {
 	setupEnv()
 	o := NewOrchestrator()
@@ -142,10 +277,235 @@ func TestGetExpression(t *testing.T) {
 	}
 }
 
+func TestDeleteExpression(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/expressions/{id}", o.GetExpression).Methods("GET")
+	r.HandleFunc("/api/v1/expressions/{id}", o.DeleteExpression).Methods("DELETE")
+	r.HandleFunc("/internal/task", o.GetTask).Methods("GET")
+	r.HandleFunc("/internal/task/{id}", o.CheckTask).Methods("GET")
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	node, _ := ast.Parse("(1 + 2) * 3")
+	o.mu.Lock()
+	expr := o.newExpression("", node, "", 0, o.lookupVariable)
+	o.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		o.processExpression(expr)
+		close(done)
+	}()
+
+	var task Task
+	deadline := time.After(1 * time.Second)
+	for task.ID == 0 {
+		resp, err := http.Get(s.URL + "/internal/task")
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var respData struct {
+				Task Task `json:"task"`
+			}
+			json.NewDecoder(resp.Body).Decode(&respData)
+			task = respData.Task
+		}
+		resp.Body.Close()
+		select {
+		case <-deadline:
+			t.Fatal("Timeout waiting for task")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	request := func(method, path string) *http.Response {
+		req, _ := http.NewRequest(method, s.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	leasePath := fmt.Sprintf("/internal/task/%d?lease=%s", task.ID, task.Lease)
+	if resp := request("GET", leasePath); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected lease holder check to succeed, got %d", resp.StatusCode)
+	}
+
+	if resp := request("DELETE", "/api/v1/expressions/"+expr.ID); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for processExpression to stop")
+	}
+	o.mu.Lock()
+	status, pending := expr.Status, len(o.pending)
+	o.mu.Unlock()
+	if status != "cancelled" || pending != 0 {
+		t.Errorf("Expected cancelled expression without pending tasks, got %s with %d", status, pending)
+	}
+	if resp := request("GET", leasePath); resp.StatusCode != http.StatusGone {
+		t.Errorf("Expected lease check to report abort, got %d", resp.StatusCode)
+	}
+
+	if resp := request("DELETE", "/api/v1/expressions/"+expr.ID); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", resp.StatusCode)
+	}
+	if resp := request("GET", "/api/v1/expressions/"+expr.ID); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected deleted expression to be gone, got %d", resp.StatusCode)
+	}
+	if resp := request("DELETE", "/api/v1/expressions/"+expr.ID); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestExpressionMetadata(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/calculate", o.AddExpression).Methods("POST")
+	r.HandleFunc("/api/v1/expressions", o.GetExpressions).Methods("GET")
+	r.HandleFunc("/api/v1/expressions/{id}", o.GetExpression).Methods("GET")
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	resp, err := http.Post(s.URL+"/api/v1/calculate", "application/json", bytes.NewBufferString(`{"expression": "(1 + 2) * -3"}`))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	var calcResp struct {
+		ID string `json:"id"`
+	}
+	json.NewDecoder(resp.Body).Decode(&calcResp)
+	resp.Body.Close()
+
+	for range 2 {
+		task, ok := o.lease(<-o.tasks)
+		if !ok {
+			t.Fatalf("Failed to lease task %d", task.ID)
+		}
+		o.deliver(Result{ID: task.ID, Result: 1})
+	}
+
+	get := func(path string) *Expression {
+		resp, err := http.Get(s.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+		var respData struct {
+			Expression *Expression `json:"expression"`
+		}
+		json.NewDecoder(resp.Body).Decode(&respData)
+		return respData.Expression
+	}
+	var expr *Expression
+	deadline := time.After(1 * time.Second)
+	for expr = get("/api/v1/expressions/" + calcResp.ID + "?include=tasks"); expr.Status != "completed"; expr = get("/api/v1/expressions/" + calcResp.ID + "?include=tasks") {
+		select {
+		case <-deadline:
+			t.Fatalf("Timeout waiting for expression, got %+v", expr)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	if expr.Expression != "(1 + 2) * -3" || expr.TaskCount != 2 || expr.CompletedTasks != 2 {
+		t.Errorf("Unexpected metadata: %+v", expr)
+	}
+	if expr.StartedAt == nil || expr.FinishedAt == nil || expr.StartedAt.Before(expr.CreatedAt) || expr.FinishedAt.Before(*expr.StartedAt) {
+		t.Errorf("Expected ordered timestamps, got created %v started %v finished %v", expr.CreatedAt, expr.StartedAt, expr.FinishedAt)
+	}
+	if len(expr.Tasks) != 2 {
+		t.Fatalf("Expected 2 tasks, got %d", len(expr.Tasks))
+	}
+	for i, task := range expr.Tasks {
+		if task.Status != "completed" || task.Attempts != 1 || task.StartedAt == nil || task.FinishedAt == nil {
+			t.Errorf("Unexpected task %d: %+v", i, task)
+		}
+	}
+	if expr.Tasks[0].Operation != "+" || expr.Tasks[1].Operation != "*" {
+		t.Errorf("Expected tasks in dispatch order, got %s and %s", expr.Tasks[0].Operation, expr.Tasks[1].Operation)
+	}
+
+	if expr := get("/api/v1/expressions/" + calcResp.ID); expr.Tasks != nil || expr.TaskCount != 2 {
+		t.Errorf("Expected tasks to be omitted by default, got %+v", expr)
+	}
+	resp, err = http.Get(s.URL + "/api/v1/expressions")
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	var listResp struct {
+		Expressions []*Expression `json:"expressions"`
+	}
+	json.NewDecoder(resp.Body).Decode(&listResp)
+	if len(listResp.Expressions) != 1 || listResp.Expressions[0].Tasks != nil {
+		t.Errorf("Expected list to omit tasks, got %+v", listResp.Expressions)
+	}
+}
+
+func TestCountTasks(t *testing.T) {
+	tests := []struct {
+		expression string
+		expected   int
+	}{
+		{"42", 0},
+		{"-3", 0},
+		{"-(1 + 2)", 2},
+		{"1 + 2 * 3", 2},
+		{"max(1, 2 + 3, sqrt(4))", 3},
+	}
+	for _, tt := range tests {
+		node, err := ast.Parse(tt.expression)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tt.expression, err)
+		}
+		if got := countTasks(node); got != tt.expected {
+			t.Errorf("Expected %d tasks for %q, got %d", tt.expected, tt.expression, got)
+		}
+	}
+}
+
+func TestTaskCountWithVariables(t *testing.T) {
+	o := NewOrchestrator()
+	lookup := func(name string) (float64, bool) {
+		return 2, name == "x"
+	}
+	tests := []struct {
+		expression string
+		expected   int
+	}{
+		{"x + 1", 1},
+		{"-x * 2", 1},
+		{"sqrt(x)", 1},
+		{"x", 0},
+		{"y + 1", 0},
+	}
+	for _, tt := range tests {
+		node, err := ast.Parse(tt.expression)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", tt.expression, err)
+		}
+		o.mu.Lock()
+		expr := o.newExpression(tt.expression, node, "", 0, lookup)
+		o.mu.Unlock()
+		if expr.TaskCount != tt.expected {
+			t.Errorf("Expected %d tasks for %q, got %d", tt.expected, tt.expression, expr.TaskCount)
+		}
+	}
+}
+
 func TestGetTask(t *testing.T) {
 	setupEnv()
 	o := NewOrchestrator()
-	task := Task{ID: 1, Arg1: 2, Arg2: 3, Operation: "+", OperationTime: 100}
+	expr := &Expression{ID: "1", Status: "pending"}
+	task := Task{ID: 1, Arg1: 2, Arg2: 3, Operation: "+", OperationTime: 100, expr: expr}
+	o.taskID = 1
+	o.pending[1] = &pendingTask{task: task, info: &TaskInfo{ID: 1, Status: "queued"}, done: make(chan Result, 1)}
 	o.tasks <- task
 	r := httptest.NewServer(http.HandlerFunc(o.GetTask))
 	defer r.Close()
@@ -166,17 +526,26 @@ func TestGetTask(t *testing.T) {
 	if respData.Task.ID != 1 || respData.Task.Arg1 != 2 || respData.Task.Arg2 != 3 {
 		t.Errorf("Expected task ID=1, Arg1=2, Arg2=3, got %+v", respData.Task)
 	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if lease := o.pending[1].lease; lease == "" || respData.Task.Lease != lease {
+		t.Errorf("Expected task to be handed out with its lease %q, got %q", lease, respData.Task.Lease)
+	}
 }
 
 func TestReceiveResult(t *testing.T) {
 	setupEnv()
 	o := NewOrchestrator()
+	done := make(chan Result, 1)
+	o.taskID = 1
+	o.pending[1] = &pendingTask{done: done, lease: "lease-1"}
 	r := httptest.NewServer(http.HandlerFunc(o.ReceiveResult))
 	defer r.Close()
 	reqBody, _ := json.Marshal(struct {
 		ID     int     `json:"id"`
 		Result float64 `json:"result"`
-	}{ID: 1, Result: 5})
+		Lease  string  `json:"lease"`
+	}{ID: 1, Result: 5, Lease: "lease-1"})
 	resp, err := http.Post(r.URL, "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
 		t.Fatalf("Failed to send request: %v", err)
@@ -185,11 +554,55 @@ func TestReceiveResult(t *testing.T) {
 	if resp.StatusCode != http.StatusOK {
 		t.Errorf("Expected status 200, got %d", resp.StatusCode)
 	}
+	select {
+	case result := <-done:
+		if result.Result != 5 {
+			t.Errorf("Expected result 5 for ID 1, got %v", result.Result)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for result delivery")
+	}
 	o.mu.Lock()
-	result, ok := o.results[1]
-	o.mu.Unlock()
-	if !ok || result != 5 {
-		t.Errorf("Expected result 5 for ID 1, got %v (found: %v)", result, ok)
+	defer o.mu.Unlock()
+	if _, ok := o.pending[1]; ok {
+		t.Error("Expected delivered task to be removed from pending")
+	}
+}
+
+func TestReceiveResultRejections(t *testing.T) {
+	o := NewOrchestrator()
+	o.taskID = 3
+	o.pending[2] = &pendingTask{done: make(chan Result, 1), lease: "lease-2"}
+	o.pending[3] = &pendingTask{done: make(chan Result, 1)}
+	r := httptest.NewServer(http.HandlerFunc(o.ReceiveResult))
+	defer r.Close()
+
+	tests := []struct {
+		name         string
+		result       Result
+		expectedCode int
+	}{
+		{"UnknownTask", Result{ID: 42, Result: 1, Lease: "lease-2"}, http.StatusNotFound},
+		{"NegativeID", Result{ID: -1, Result: 1}, http.StatusNotFound},
+		{"CompletedTask", Result{ID: 1, Result: 1, Lease: "lease-1"}, http.StatusConflict},
+		{"ExpiredLease", Result{ID: 3, Result: 1, Lease: "lease-3"}, http.StatusConflict},
+		{"WrongLeaseHolder", Result{ID: 2, Result: 1, Lease: "lease-x"}, http.StatusForbidden},
+		{"MissingLease", Result{ID: 2, Result: 1}, http.StatusForbidden},
+		{"LeaseHolder", Result{ID: 2, Result: 1, Lease: "lease-2"}, http.StatusOK},
+		{"Duplicate", Result{ID: 2, Result: 1, Lease: "lease-2"}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqBody, _ := json.Marshal(tt.result)
+			resp, err := http.Post(r.URL, "application/json", bytes.NewBuffer(reqBody))
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.expectedCode {
+				t.Errorf("Expected status %d, got %d", tt.expectedCode, resp.StatusCode)
+			}
+		})
 	}
 }
 
@@ -228,10 +641,14 @@ func TestFullWorkflow(t *testing.T) {
 		t.Fatalf("Failed to decode task: %v", err)
 	}
 	result := taskResp.Task.Arg1 + taskResp.Task.Arg2
+	if taskResp.Task.Lease == "" {
+		t.Fatal("Expected task to carry a lease")
+	}
 	reqBody, _ = json.Marshal(struct {
 		ID     int     `json:"id"`
 		Result float64 `json:"result"`
-	}{ID: taskResp.Task.ID, Result: result})
+		Lease  string  `json:"lease"`
+	}{ID: taskResp.Task.ID, Result: result, Lease: taskResp.Task.Lease})
 	resp, err = http.Post(s.URL+"/internal/task", "application/json", bytes.NewBuffer(reqBody))
 	if err != nil {
 		t.Fatalf("Failed to send result: %v", err)
@@ -266,3 +683,889 @@ func TestFullWorkflow(t *testing.T) {
 		}
 	}
 }
+
+func TestEvaluateNegatedLiteral(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	node, err := ast.Parse("-3")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expr := &Expression{ID: "1", Status: "pending", Node: node}
+	result, err := o.evaluateNode(context.Background(), node, "0", expr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.float != -3 || result.exact.RatString() != "-3" {
+		t.Errorf("Expected -3, got %+v", result)
+	}
+	if len(expr.Tasks) != 0 {
+		t.Errorf("Expected negated literal to be folded, got %d tasks", len(expr.Tasks))
+	}
+}
+
+func TestFunctionTask(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	node, err := ast.Parse("max(1, 2, 3)")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expr := &Expression{ID: "1", Status: "pending", Node: node}
+	go o.processExpression(expr)
+
+	select {
+	case task := <-o.tasks:
+		if task.Operation != "max" {
+			t.Errorf("Expected operation 'max', got %q", task.Operation)
+		}
+		if len(task.Args) != 3 || task.Args[0] != 1 || task.Args[1] != 2 || task.Args[2] != 3 {
+			t.Errorf("Expected args [1 2 3], got %v", task.Args)
+		}
+		if task.OperationTime != 100 {
+			t.Errorf("Expected operation time 100, got %d", task.OperationTime)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for function task")
+	}
+}
+
+func TestSetVariable(t *testing.T) {
+	o := NewOrchestrator()
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/variables/{name}", o.SetVariable).Methods("PUT")
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	tests := []struct {
+		name         string
+		variable     string
+		body         string
+		expectedCode int
+	}{
+		{"ValidVariable", "x", `{"value": 2.5}`, http.StatusOK},
+		{"InvalidName", "2x", `{"value": 1}`, http.StatusUnprocessableEntity},
+		{"FunctionName", "sqrt", `{"value": 1}`, http.StatusUnprocessableEntity},
+		{"MissingValue", "y", `{}`, http.StatusUnprocessableEntity},
+		{"InvalidBody", "y", `{"value": "abc"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPut, s.URL+"/api/v1/variables/"+tt.variable, bytes.NewBufferString(tt.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.expectedCode {
+				t.Errorf("Expected status %d, got %d", tt.expectedCode, resp.StatusCode)
+			}
+		})
+	}
+
+	o.mu.Lock()
+	value, ok := o.variables["x"]
+	o.mu.Unlock()
+	if !ok || value != 2.5 {
+		t.Errorf("Expected x=2.5, got %v (found: %v)", value, ok)
+	}
+}
+
+func TestAddExpressionWithVariables(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	o.variables["x"] = 4
+	r := httptest.NewServer(http.HandlerFunc(o.AddExpression))
+	defer r.Close()
+
+	submit := func(expression string) *Expression {
+		reqBody, _ := json.Marshal(map[string]string{"expression": expression})
+		resp, err := http.Post(r.URL, "application/json", bytes.NewBuffer(reqBody))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+		}
+		var respData struct {
+			ID string `json:"id"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return o.expressions[respData.ID]
+	}
+
+	expr := submit("x * 2")
+	select {
+	case task := <-o.tasks:
+		if task.Arg1 != 4 || task.Arg2 != 2 {
+			t.Errorf("Expected task 4 * 2, got %+v", task)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for task")
+	}
+	if expr.Status != "pending" {
+		t.Errorf("Expected status 'pending', got %q", expr.Status)
+	}
+
+	expr = submit("y + 1")
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if expr.Status != "error" {
+		t.Errorf("Expected status 'error', got %q", expr.Status)
+	}
+	if expr.Error != "undefined variable: y" {
+		t.Errorf("Expected undefined variable error, got %q", expr.Error)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	o.variables["b"] = 1
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/templates", o.AddTemplate).Methods("POST")
+	r.HandleFunc("/api/v1/templates/{id}/evaluate", o.EvaluateTemplate).Methods("POST")
+	s := httptest.NewServer(r)
+	defer s.Close()
+
+	reqBody, _ := json.Marshal(map[string]string{"expression": "a * x + b"})
+	resp, err := http.Post(s.URL+"/api/v1/templates", "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	var tmplResp struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tmplResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		id           string
+		body         string
+		expectedCode int
+	}{
+		{"UnknownTemplate", "42", `{"bindings": [{"a": 1}]}`, http.StatusNotFound},
+		{"NoBindings", tmplResp.ID, `{"bindings": []}`, http.StatusUnprocessableEntity},
+		{"InvalidBindings", tmplResp.ID, `{"bindings": [{"a": "x"}]}`, http.StatusUnprocessableEntity},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(s.URL+"/api/v1/templates/"+tt.id+"/evaluate", "application/json", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.expectedCode {
+				t.Errorf("Expected status %d, got %d", tt.expectedCode, resp.StatusCode)
+			}
+		})
+	}
+
+	body := `{"bindings": [{"a": 2, "x": 3}, {"a": 4, "x": 5, "b": 6}, {"a": 1}]}`
+	resp, err = http.Post(s.URL+"/api/v1/templates/"+tmplResp.ID+"/evaluate", "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	var evalResp struct {
+		IDs []string `json:"ids"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&evalResp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(evalResp.IDs) != 3 {
+		t.Fatalf("Expected 3 expressions, got %d", len(evalResp.IDs))
+	}
+
+	products := make(map[float64]bool)
+	for range 2 {
+		select {
+		case task := <-o.tasks:
+			products[task.Arg1*task.Arg2] = true
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timeout waiting for task")
+		}
+	}
+	if !products[6] || !products[20] {
+		t.Errorf("Expected tasks 2 * 3 and 4 * 5, got %v", products)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if expr := o.expressions[evalResp.IDs[2]]; expr.Status != "error" {
+		t.Errorf("Expected status 'error' for incomplete binding, got %q", expr.Status)
+	}
+	if o.templates[tmplResp.ID].Node.Left.Left.Variable != "a" {
+		t.Error("Expected template tree to stay unresolved")
+	}
+}
+
+func TestAddExpressionSyntaxError(t *testing.T) {
+	o := NewOrchestrator()
+	r := httptest.NewServer(http.HandlerFunc(o.AddExpression))
+	defer r.Close()
+
+	reqBody, _ := json.Marshal(map[string]string{"expression": "2 + * 3"})
+	resp, err := http.Post(r.URL, "application/json", bytes.NewBuffer(reqBody))
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422, got %d", resp.StatusCode)
+	}
+	var respData struct {
+		Error    string `json:"error"`
+		Position int    `json:"position"`
+		Token    string `json:"token"`
+		Expected string `json:"expected"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if respData.Position != 4 || respData.Token != "*" {
+		t.Errorf("Expected error at position 4 on '*', got %+v", respData)
+	}
+	if respData.Error == "" || respData.Expected == "" {
+		t.Errorf("Expected error and expected descriptions, got %+v", respData)
+	}
+}
+
+func TestExactMode(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	r := httptest.NewServer(http.HandlerFunc(o.AddExpression))
+	defer r.Close()
+
+	tests := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{"InvalidMode", `{"expression": "1/3", "mode": "fuzzy"}`, http.StatusUnprocessableEntity},
+		{"InexactFunction", `{"expression": "sqrt(2)", "mode": "exact"}`, http.StatusUnprocessableEntity},
+		{"ExactFunction", `{"expression": "max(1/3, 1/4)", "mode": "exact"}`, http.StatusCreated},
+		{"FloatMode", `{"expression": "sqrt(2)", "mode": "float"}`, http.StatusCreated},
+		{"DecimalMode", `{"expression": "sqrt(2)", "precision": 50}`, http.StatusCreated},
+		{"ExplicitDecimalMode", `{"expression": "1/3", "mode": "decimal", "precision": 50}`, http.StatusCreated},
+		{"DecimalWithoutPrecision", `{"expression": "1/3", "mode": "decimal"}`, http.StatusUnprocessableEntity},
+		{"ExactWithPrecision", `{"expression": "1/3", "mode": "exact", "precision": 50}`, http.StatusUnprocessableEntity},
+		{"NegativePrecision", `{"expression": "1/3", "precision": -1}`, http.StatusUnprocessableEntity},
+		{"HugePrecision", `{"expression": "1/3", "precision": 1000000}`, http.StatusUnprocessableEntity},
+		{"DecimalUnsupportedFunction", `{"expression": "sin(1)", "precision": 50}`, http.StatusUnprocessableEntity},
+		{"FloatOutOfRange", `{"expression": "1e400 / 1e399"}`, http.StatusUnprocessableEntity},
+		{"ExactOutOfFloatRange", `{"expression": "1e400 / 1e399", "mode": "exact"}`, http.StatusCreated},
+		{"DecimalOutOfFloatRange", `{"expression": "0x1_0000_0000_0000_0000 + 1e400", "precision": 50}`, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(r.URL, "application/json", bytes.NewBufferString(tt.body))
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.expectedCode {
+				t.Errorf("Expected status %d, got %d", tt.expectedCode, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestExactEvaluation(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	node, err := ast.Parse("1/3*3")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expr := &Expression{ID: "1", Status: "pending", Mode: "exact", Node: node}
+	done := make(chan struct{})
+	go func() {
+		o.processExpression(expr)
+		close(done)
+	}()
+
+	steps := []struct {
+		operation string
+		operands  []string
+		result    string
+	}{
+		{"/", []string{"1", "3"}, "1/3"},
+		{"*", []string{"1/3", "3"}, "1"},
+	}
+	for _, step := range steps {
+		select {
+		case task := <-o.tasks:
+			if task.Mode != "exact" || task.Operation != step.operation {
+				t.Fatalf("Expected exact %q task, got %+v", step.operation, task)
+			}
+			if len(task.Operands) != len(step.operands) || task.Operands[0] != step.operands[0] || task.Operands[1] != step.operands[1] {
+				t.Errorf("Expected operands %v, got %v", step.operands, task.Operands)
+			}
+			o.deliver(Result{ID: task.ID, Value: step.result, Mode: "exact"})
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timeout waiting for task")
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for expression to complete")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if expr.Status != "completed" || *expr.Result != 1 {
+		t.Errorf("Expected completed result 1, got %s %v", expr.Status, *expr.Result)
+	}
+	if expr.Exact == nil || expr.Exact.Numerator != "1" || expr.Exact.Denominator != "1" || expr.Exact.Decimal != "1" {
+		t.Errorf("Expected exact result 1/1, got %+v", expr.Exact)
+	}
+}
+
+func TestExactLiteralOutOfFloatRange(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	node, err := ast.Parse("1e400 / 1e399")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	o.mu.Lock()
+	expr := o.newExpression("1e400 / 1e399", node, "exact", 0, o.lookupVariable)
+	o.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		o.processExpression(expr)
+		close(done)
+	}()
+
+	select {
+	case task := <-o.tasks:
+		if _, err := json.Marshal(task); err != nil {
+			t.Fatalf("Expected task to be encodable, got %v", err)
+		}
+		if len(task.Operands) != 2 || task.Operands[0] != "1"+strings.Repeat("0", 400) {
+			t.Fatalf("Expected exact operands, got %v", task.Operands)
+		}
+		o.deliver(Result{ID: task.ID, Value: "10", Mode: "exact"})
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for task")
+	}
+	<-done
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if expr.Status != "completed" || expr.Exact == nil || expr.Exact.Numerator != "10" {
+		t.Errorf("Expected exact result 10, got %s %+v", expr.Status, expr.Exact)
+	}
+}
+
+func TestNewExactResult(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected ExactResult
+	}{
+		{"1/3", ExactResult{"1", "3", "0.33333333333333333333"}},
+		{"-5/2", ExactResult{"-5", "2", "-2.5"}},
+		{"7", ExactResult{"7", "1", "7"}},
+		{"0", ExactResult{"0", "1", "0"}},
+	}
+
+	for _, tt := range tests {
+		x, _ := new(big.Rat).SetString(tt.value)
+		if result := newExactResult(x); *result != tt.expected {
+			t.Errorf("Expected %+v for %s, got %+v", tt.expected, tt.value, *result)
+		}
+	}
+}
+
+func TestDecimalEvaluation(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	node, err := ast.Parse("0.1 + 0.2")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expr := &Expression{ID: "1", Status: "pending", Mode: "decimal", Precision: 30, Node: node}
+	done := make(chan struct{})
+	go func() {
+		o.processExpression(expr)
+		close(done)
+	}()
+
+	select {
+	case task := <-o.tasks:
+		if task.Mode != "decimal" || task.Precision != precisionBits(30) {
+			t.Fatalf("Expected decimal task with %d bits, got %+v", precisionBits(30), task)
+		}
+		if len(task.Operands) != 2 || task.Operands[0] != "0.1" || task.Operands[1] != "0.2" {
+			t.Errorf("Expected operands [0.1 0.2], got %v", task.Operands)
+		}
+		o.deliver(Result{ID: task.ID, Result: 0.3, Value: "0.3", Mode: "decimal"})
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for task")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for expression to complete")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if expr.Status != "completed" || expr.Value != "0.3" || *expr.Result != 0.3 {
+		t.Errorf("Expected completed result 0.3, got %s %q %v", expr.Status, expr.Value, *expr.Result)
+	}
+}
+
+func TestExpressionError(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	node, err := ast.Parse("(1 / 0) + (2 * 3)")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expr := &Expression{ID: "1", Status: "pending", Node: node}
+	done := make(chan struct{})
+	go func() {
+		o.processExpression(expr)
+		close(done)
+	}()
+
+	for divided := false; !divided; {
+		select {
+		case task := <-o.tasks:
+			if task.Operation != "/" {
+				continue
+			}
+			o.deliver(Result{ID: task.ID, Error: &TaskError{Code: "division_by_zero", Message: "division by zero"}})
+			divided = true
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timeout waiting for task")
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for expression to fail")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if expr.Status != "error" || expr.Error != "division by zero" {
+		t.Errorf("Expected error status with message, got %s %q", expr.Status, expr.Error)
+	}
+	if expr.Result != nil {
+		t.Errorf("Expected no result, got %v", *expr.Result)
+	}
+	if len(expr.Tasks) != 2 {
+		t.Errorf("Expected the addition never to be dispatched, got %d tasks", len(expr.Tasks))
+	}
+}
+
+func TestParallelEvaluation(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	node, err := ast.Parse("(1 + 2) * (3 + 4)")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expr := &Expression{ID: "1", Status: "pending", Node: node}
+	done := make(chan struct{})
+	go func() {
+		o.processExpression(expr)
+		close(done)
+	}()
+
+	var sums []Task
+	for range 2 {
+		select {
+		case task := <-o.tasks:
+			sums = append(sums, task)
+		case <-time.After(1 * time.Second):
+			t.Fatal("Timeout waiting for independent tasks to be dispatched together")
+		}
+	}
+	for _, task := range sums {
+		if task.Operation != "+" {
+			t.Errorf("Expected addition task, got %+v", task)
+		}
+		o.deliver(Result{ID: task.ID, Result: task.Arg1 + task.Arg2})
+	}
+
+	select {
+	case task := <-o.tasks:
+		if task.Operation != "*" || task.Arg1*task.Arg2 != 21 {
+			t.Errorf("Expected multiplication of 3 and 7, got %+v", task)
+		}
+		o.deliver(Result{ID: task.ID, Result: task.Arg1 * task.Arg2})
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for multiplication task")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for expression to complete")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if expr.Status != "completed" || *expr.Result != 21 {
+		t.Errorf("Expected completed result 21, got %s %v", expr.Status, expr.Result)
+	}
+}
+
+func TestInvalidResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		result Result
+		task   Task
+	}{
+		{"BadExactValue", Result{Value: "abc", Mode: "exact"}, Task{Mode: "exact"}},
+		{"MissingDecimalValue", Result{Mode: "decimal"}, Task{Mode: "decimal", Precision: 64}},
+		{"ModeMismatch", Result{Value: "1", Mode: "exact"}, Task{Mode: "decimal", Precision: 64}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resultValue(tt.result, tt.task)
+			var taskErr *TaskError
+			if !errors.As(err, &taskErr) || taskErr.Code != "invalid_result" {
+				t.Errorf("Expected invalid_result error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestGetTaskSkipsFailedExpressions(t *testing.T) {
+	o := NewOrchestrator()
+	failed := &Expression{ID: "1", Status: "error"}
+	pending := &Expression{ID: "2", Status: "pending"}
+	o.tasks <- Task{ID: 1, Operation: "+", expr: failed}
+	o.tasks <- Task{ID: 2, Operation: "*", expr: pending}
+	o.pending[2] = &pendingTask{task: Task{ID: 2}, info: &TaskInfo{ID: 2, Status: "queued"}, done: make(chan Result, 1)}
+	r := httptest.NewServer(http.HandlerFunc(o.GetTask))
+	defer r.Close()
+
+	resp, err := http.Get(r.URL)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	var respData struct {
+		Task Task `json:"task"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&respData); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if respData.Task.ID != 2 {
+		t.Errorf("Expected task 2, got %+v", respData.Task)
+	}
+	o.mu.Lock()
+	if info := o.pending[2].info; info.Status != "running" || info.Attempts != 1 || info.StartedAt == nil {
+		t.Errorf("Expected task info to record the lease, got %+v", info)
+	}
+	if p := o.pending[2]; p.attempts != 1 || p.deadline.IsZero() {
+		t.Errorf("Expected task 2 to be leased, got %+v", p)
+	}
+	o.mu.Unlock()
+
+	resp, err = http.Get(r.URL)
+	if err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestCancelledTaskIsForgotten(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	node, err := ast.Parse("1 + 2")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expr := &Expression{ID: "1", Status: "pending", Node: node}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := o.evaluateNode(ctx, node, "0", expr)
+		errs <- err
+	}()
+
+	task := <-o.tasks
+	cancel()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for cancellation")
+	}
+	if o.deliver(Result{ID: task.ID, Result: 3}) {
+		t.Error("Expected late result for cancelled task to be dropped")
+	}
+}
+
+func startBenchmarkAgents(o *Orchestrator, workers int) func() {
+	stop := make(chan struct{})
+	for range workers {
+		go func() {
+			for {
+				select {
+				case task := <-o.tasks:
+					o.deliver(Result{ID: task.ID, Result: task.Arg1 + task.Arg2})
+				case <-stop:
+					return
+				}
+			}
+		}()
+	}
+	return func() { close(stop) }
+}
+
+func BenchmarkTaskLatency(b *testing.B) {
+	setupEnv()
+	o := NewOrchestrator()
+	defer startBenchmarkAgents(o, 1)()
+	node, _ := ast.Parse("1 + 2")
+	expr := &Expression{ID: "1", Status: "pending", Node: node}
+
+	b.ResetTimer()
+	for range b.N {
+		if _, err := o.evaluateNode(context.Background(), node, "0", expr); err != nil {
+			b.Fatal(err)
+		}
+		expr.Tasks = expr.Tasks[:0]
+	}
+}
+
+func BenchmarkConcurrentExpressions(b *testing.B) {
+	setupEnv()
+	for _, concurrency := range []int{1000, 5000} {
+		b.Run(strconv.Itoa(concurrency), func(b *testing.B) {
+			o := NewOrchestrator()
+			defer startBenchmarkAgents(o, 16)()
+			node, _ := ast.Parse("(1 + 2) + (3 + 4) + 5")
+
+			var latency time.Duration
+			b.ResetTimer()
+			for range b.N {
+				var (
+					wg sync.WaitGroup
+					mu sync.Mutex
+				)
+				for range concurrency {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						expr := &Expression{Status: "pending", Node: node.Clone()}
+						start := time.Now()
+						o.processExpression(expr)
+						elapsed := time.Since(start)
+						mu.Lock()
+						latency += elapsed
+						mu.Unlock()
+					}()
+				}
+				wg.Wait()
+			}
+			b.StopTimer()
+
+			total := float64(b.N * concurrency)
+			b.ReportMetric(total/b.Elapsed().Seconds(), "expr/s")
+			b.ReportMetric(float64(latency.Microseconds())/total, "µs/expr")
+		})
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	o.maxAttempts = 2
+	node, err := ast.Parse("1 + 2")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	expr := &Expression{ID: "1", Status: "pending", Node: node}
+	done := make(chan struct{})
+	go func() {
+		o.processExpression(expr)
+		close(done)
+	}()
+	r := httptest.NewServer(http.HandlerFunc(o.GetTask))
+	defer r.Close()
+
+	pull := func() Task {
+		deadline := time.After(1 * time.Second)
+		for {
+			resp, err := http.Get(r.URL)
+			if err != nil {
+				t.Fatalf("Failed to send request: %v", err)
+			}
+			if resp.StatusCode == http.StatusOK {
+				var respData struct {
+					Task Task `json:"task"`
+				}
+				err := json.NewDecoder(resp.Body).Decode(&respData)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				return respData.Task
+			}
+			resp.Body.Close()
+			select {
+			case <-deadline:
+				t.Fatal("Timeout waiting for task")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	first := pull()
+	o.expireLeases(time.Now())
+	o.mu.Lock()
+	if _, ok := o.pending[first.ID]; !ok {
+		t.Fatal("Expected unexpired lease to stay pending")
+	}
+	o.mu.Unlock()
+
+	o.expireLeases(time.Now().Add(o.leaseGrace + time.Second))
+	second := pull()
+	if second.ID != first.ID {
+		t.Errorf("Expected task %d to be re-dispatched, got %d", first.ID, second.ID)
+	}
+
+	o.expireLeases(time.Now().Add(o.leaseGrace + time.Second))
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		t.Fatal("Timeout waiting for expression to fail")
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if expr.Status != "error" || expr.Error != fmt.Sprintf("task %d was not completed after 2 attempts", first.ID) {
+		t.Errorf("Expected lease expiry error, got %s %q", expr.Status, expr.Error)
+	}
+	if len(o.pending) != 0 {
+		t.Errorf("Expected no pending tasks, got %d", len(o.pending))
+	}
+}
+
+func TestReleaseTask(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	s := httptest.NewServer(o.Router())
+	defer s.Close()
+
+	node, _ := ast.Parse("1 + 2")
+	o.mu.Lock()
+	o.startExpression(o.newExpression("1 + 2", node, "", 0, o.lookupVariable))
+	o.mu.Unlock()
+
+	task, ok := o.lease(<-o.tasks)
+	if !ok {
+		t.Fatal("Failed to lease task")
+	}
+	release := func(id int, lease string) int {
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("%s/internal/task/%d?lease=%s", s.URL, id, lease), nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := release(42, task.Lease); code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown task, got %d", code)
+	}
+	if code := release(task.ID, "other"); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for another lease, got %d", code)
+	}
+	if code := release(task.ID, task.Lease); code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", code)
+	}
+	if code := release(task.ID, task.Lease); code != http.StatusConflict {
+		t.Errorf("Expected status 409 for released lease, got %d", code)
+	}
+
+	again, ok := o.lease(<-o.tasks)
+	if !ok || again.ID != task.ID || again.Lease == task.Lease {
+		t.Fatalf("Expected task %d to be re-dispatched with a new lease, got %+v", task.ID, again)
+	}
+	o.mu.Lock()
+	attempts := o.pending[task.ID].attempts
+	o.mu.Unlock()
+	if attempts != 1 {
+		t.Errorf("Expected released attempt not to count, got %d attempts", attempts)
+	}
+	o.deliver(Result{ID: again.ID, Result: 3})
+	if err := o.Drain(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	setupEnv()
+	o := NewOrchestrator()
+	s := httptest.NewServer(o.Router())
+	defer s.Close()
+
+	calculate := func() *http.Response {
+		resp, err := http.Post(s.URL+"/api/v1/calculate", "application/json", bytes.NewBufferString(`{"expression": "2 * 3"}`))
+		if err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	if resp := calculate(); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	task, ok := o.lease(<-o.tasks)
+	if !ok {
+		t.Fatal("Failed to lease task")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := o.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected drain to time out with a task in flight, got %v", err)
+	}
+	if resp := calculate(); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 while draining, got %d", resp.StatusCode)
+	}
+
+	o.deliver(Result{ID: task.ID, Result: 6})
+	if err := o.Drain(context.Background()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.expressions) != 1 {
+		t.Errorf("Expected 1 expression, got %d", len(o.expressions))
+	}
+	for _, expr := range o.expressions {
+		if expr.Status != "completed" {
+			t.Errorf("Expected in-flight expression to complete, got %s", expr.Status)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package orchestrator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/Yorshik/final_task_sprint_1/internal/ast"
+)
+
+type Storage interface {
+	SaveExpression(expr *Expression) error
+	SaveResult(exprID, node string, result Result, info *TaskInfo) error
+	SaveTaskID(id int) error
+	DeleteExpression(id string) error
+	Flush() error
+	Load() (*State, error)
+	Close() error
+}
+
+type State struct {
+	Expressions []StoredExpression
+	TaskID      int
+}
+
+type StoredExpression struct {
+	Expression *Expression
+	Results    map[string]Result
+}
+
+type record struct {
+	Kind       string      `json:"kind"`
+	Expression *Expression `json:"expression,omitempty"`
+	Node       *ast.Node   `json:"node,omitempty"`
+	ID         string      `json:"id,omitempty"`
+	Path       string      `json:"path,omitempty"`
+	Result     *Result     `json:"result,omitempty"`
+	Task       *TaskInfo   `json:"task,omitempty"`
+	TaskID     int         `json:"task_id,omitempty"`
+}
+
+const (
+	recordExpression = "expression"
+	recordResult     = "result"
+	recordTaskID     = "task_id"
+	recordDelete     = "delete"
+)
+
+var errStorageClosed = errors.New("storage is closed")
+
+type FileStorage struct {
+	path string
+	file *os.File
+
+	mu      sync.Mutex
+	written *sync.Cond
+	pending []byte
+	queued  uint64
+	synced  uint64
+	err     error
+	closed  bool
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func OpenFileStorage(path string) (*FileStorage, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	records, err := readRecords(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeRecords(path, compact(records)); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStorage{path: path, file: file, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	s.written = sync.NewCond(&s.mu)
+	go s.run()
+	return s, nil
+}
+
+func (s *FileStorage) SaveExpression(expr *Expression) error {
+	return s.append(record{Kind: recordExpression, Expression: expr, Node: expr.Node})
+}
+
+func (s *FileStorage) SaveResult(exprID, node string, result Result, info *TaskInfo) error {
+	return s.append(record{Kind: recordResult, ID: exprID, Path: node, Result: &result, Task: info})
+}
+
+func (s *FileStorage) SaveTaskID(id int) error {
+	return s.append(record{Kind: recordTaskID, TaskID: id})
+}
+
+func (s *FileStorage) DeleteExpression(id string) error {
+	return s.append(record{Kind: recordDelete, ID: id})
+}
+
+// append only queues the record, so callers may hold their own locks; Flush waits until it is on disk.
+func (s *FileStorage) append(rec record) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStorageClosed
+	}
+	s.pending = append(append(s.pending, line...), '\n')
+	s.queued++
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (s *FileStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for target := s.queued; s.synced < target && s.err == nil; {
+		s.written.Wait()
+	}
+	return s.err
+}
+
+func (s *FileStorage) run() {
+	defer close(s.done)
+	for range s.wake {
+		s.write()
+	}
+	s.write()
+}
+
+func (s *FileStorage) write() {
+	s.mu.Lock()
+	batch, seq := s.pending, s.queued
+	s.pending = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	_, err := s.file.Write(batch)
+	if err == nil {
+		err = s.file.Sync()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced = seq
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.written.Broadcast()
+}
+
+func (s *FileStorage) Load() (*State, error) {
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	records, err := readRecords(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	records = compact(records)
+
+	state := &State{}
+	index := make(map[string]int)
+	for _, rec := range records {
+		switch rec.Kind {
+		case recordTaskID:
+			state.TaskID = rec.TaskID
+		case recordExpression:
+			rec.Expression.Node = rec.Node
+			if rec.Expression.Status == "pending" {
+				// Tasks still running at shutdown are dispatched again; completed ones come from their results.
+				rec.Expression.Tasks = nil
+			}
+			index[rec.Expression.ID] = len(state.Expressions)
+			state.Expressions = append(state.Expressions, StoredExpression{Expression: rec.Expression, Results: make(map[string]Result)})
+		}
+	}
+	for _, rec := range records {
+		if i, ok := index[rec.ID]; ok && rec.Kind == recordResult {
+			state.Expressions[i].Results[rec.Path] = *rec.Result
+			if rec.Task != nil {
+				expr := state.Expressions[i].Expression
+				expr.Tasks = append(expr.Tasks, rec.Task)
+			}
+		}
+	}
+	for _, stored := range state.Expressions {
+		slices.SortFunc(stored.Expression.Tasks, func(a, b *TaskInfo) int {
+			return a.ID - b.ID
+		})
+	}
+	return state, nil
+}
+
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errStorageClosed
+	}
+	s.closed = true
+	close(s.wake)
+	s.mu.Unlock()
+
+	<-s.done
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return s.err
+}
+
+func readRecords(path string) ([]record, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var records []record
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for line := 1; ; line++ {
+		text, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A line without a trailing newline is a write interrupted by a crash.
+			return records, nil
+		}
+		var rec record
+		if err := json.Unmarshal(text, &rec); err != nil || !rec.valid() {
+			return nil, fmt.Errorf("%s:%d: corrupted record", path, line)
+		}
+		records = append(records, rec)
+	}
+}
+
+func (r record) valid() bool {
+	switch r.Kind {
+	case recordExpression:
+		return r.Expression != nil && r.Expression.ID != "" && r.Node != nil
+	case recordResult:
+		return r.ID != "" && r.Result != nil
+	case recordTaskID:
+		return r.TaskID > 0
+	case recordDelete:
+		return r.ID != ""
+	default:
+		return false
+	}
+}
+
+func compact(records []record) []record {
+	latest := make(map[string]int)
+	taskID := 0
+	for i, rec := range records {
+		switch rec.Kind {
+		case recordExpression:
+			latest[rec.Expression.ID] = i
+		case recordTaskID:
+			taskID = max(taskID, rec.TaskID)
+		case recordDelete:
+			delete(latest, rec.ID)
+		}
+	}
+
+	var compacted []record
+	if taskID > 0 {
+		compacted = append(compacted, record{Kind: recordTaskID, TaskID: taskID})
+	}
+	for i, rec := range records {
+		switch rec.Kind {
+		case recordExpression:
+			if j, ok := latest[rec.Expression.ID]; ok && j == i {
+				compacted = append(compacted, rec)
+			}
+		case recordResult:
+			j, ok := latest[rec.ID]
+			if ok && records[j].Expression.Status == "pending" {
+				compacted = append(compacted, rec)
+			}
+		}
+	}
+	return compacted
+}
+
+func writeRecords(path string, records []record) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,299 @@
+package orchestrator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Yorshik/final_task_sprint_1/internal/ast"
+)
+
+func openTestStorage(t *testing.T, path string) *FileStorage {
+	t.Helper()
+	storage, err := OpenFileStorage(path)
+	if err != nil {
+		t.Fatalf("Failed to open storage: %v", err)
+	}
+	return storage
+}
+
+func parseNode(t *testing.T, src string) *ast.Node {
+	t.Helper()
+	node, err := ast.Parse(src)
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %v", src, err)
+	}
+	return node
+}
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "orchestrator.log")
+	storage := openTestStorage(t, path)
+
+	pending := &Expression{ID: "1", Status: "pending", Mode: "exact", Node: parseNode(t, "1/3 + x")}
+	pending.Node.Resolve(func(string) (float64, bool) { return 2, true })
+	completed := &Expression{ID: "2", Status: "pending", Node: parseNode(t, "2 * 3")}
+	storage.SaveExpression(pending)
+	storage.SaveExpression(completed)
+	storage.SaveResult("1", "0.0", Result{ID: 1, Result: 1.0 / 3, Value: "1/3", Mode: "exact"}, nil)
+	storage.SaveResult("2", "0", Result{ID: 2, Result: 6}, nil)
+	value := 6.0
+	completed.Status = "completed"
+	completed.Result = &value
+	storage.SaveExpression(completed)
+	storage.Close()
+
+	storage = openTestStorage(t, path)
+	defer storage.Close()
+	state, err := storage.Load()
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	stored := state.Expressions
+	if len(stored) != 2 {
+		t.Fatalf("Expected 2 expressions, got %d", len(stored))
+	}
+
+	first := stored[0]
+	if first.Expression.ID != "1" || first.Expression.Status != "pending" || first.Expression.Mode != "exact" {
+		t.Errorf("Unexpected first expression: %+v", first.Expression)
+	}
+	if right := first.Expression.Node.Right; right == nil || right.Exact.RatString() != "2" {
+		t.Errorf("Expected resolved variable to be restored, got %+v", right)
+	}
+	if result, ok := first.Results["0.0"]; !ok || result.Value != "1/3" {
+		t.Errorf("Expected intermediate result to be restored, got %+v", first.Results)
+	}
+
+	second := stored[1]
+	if second.Expression.Status != "completed" || second.Expression.Result == nil || *second.Expression.Result != 6 {
+		t.Errorf("Expected latest state of second expression, got %+v", second.Expression)
+	}
+	if len(second.Results) != 0 {
+		t.Errorf("Expected results of finished expression to be compacted, got %+v", second.Results)
+	}
+}
+
+func TestFileStorageDelete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orchestrator.log")
+	storage := openTestStorage(t, path)
+	storage.SaveExpression(&Expression{ID: "1", Status: "completed", Node: parseNode(t, "1 + 2")})
+	storage.SaveExpression(&Expression{ID: "2", Status: "cancelled", Node: parseNode(t, "3 + 4")})
+	storage.DeleteExpression("1")
+	storage.Close()
+
+	storage = openTestStorage(t, path)
+	defer storage.Close()
+	state, err := storage.Load()
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if len(state.Expressions) != 1 || state.Expressions[0].Expression.ID != "2" {
+		t.Errorf("Expected only expression 2 to remain, got %+v", state.Expressions)
+	}
+}
+
+func TestFileStorageInterruptedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orchestrator.log")
+	storage := openTestStorage(t, path)
+	storage.SaveExpression(&Expression{ID: "1", Status: "pending", Node: parseNode(t, "1 + 2")})
+	storage.Close()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Failed to open log: %v", err)
+	}
+	file.WriteString(`{"kind":"result","id":"1","path":"0","res`)
+	file.Close()
+
+	storage = openTestStorage(t, path)
+	storage.SaveExpression(&Expression{ID: "2", Status: "pending", Node: parseNode(t, "3 + 4")})
+	storage.Close()
+
+	storage = openTestStorage(t, path)
+	defer storage.Close()
+	state, err := storage.Load()
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	stored := state.Expressions
+	if len(stored) != 2 || len(stored[0].Results) != 0 {
+		t.Errorf("Expected interrupted record to be dropped, got %+v", stored)
+	}
+}
+
+func TestFileStorageFlush(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orchestrator.log")
+	storage := openTestStorage(t, path)
+	node := parseNode(t, "1 + 2")
+	for i := range 100 {
+		if err := storage.SaveExpression(&Expression{ID: fmt.Sprint(i), Status: "pending", Node: node}); err != nil {
+			t.Fatalf("Failed to save: %v", err)
+		}
+	}
+	if err := storage.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	records, err := readRecords(path)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if len(records) != 100 || records[99].Expression.ID != "99" {
+		t.Errorf("Expected 100 records in order after flush, got %d", len(records))
+	}
+
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if err := storage.SaveTaskID(1); err != errStorageClosed {
+		t.Errorf("Expected closed storage to reject writes, got %v", err)
+	}
+}
+
+func TestFileStorageCorruptedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orchestrator.log")
+	os.WriteFile(path, []byte("not json\n"), 0o644)
+	if _, err := OpenFileStorage(path); err == nil {
+		t.Error("Expected corrupted log to be rejected")
+	}
+}
+
+func TestTaskIDSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orchestrator.log")
+	storage := openTestStorage(t, path)
+	o, err := NewOrchestratorWithStorage(storage)
+	if err != nil {
+		t.Fatalf("Failed to open orchestrator: %v", err)
+	}
+	for range 3 {
+		o.nextTaskID()
+	}
+	storage.Close()
+
+	storage = openTestStorage(t, path)
+	defer storage.Close()
+	o, err = NewOrchestratorWithStorage(storage)
+	if err != nil {
+		t.Fatalf("Failed to resume: %v", err)
+	}
+	if id := o.nextTaskID(); id <= taskIDBlock {
+		t.Errorf("Expected task IDs to continue past the reserved block, got %d", id)
+	}
+}
+
+func TestResumeExpression(t *testing.T) {
+	setupEnv()
+	path := filepath.Join(t.TempDir(), "orchestrator.log")
+	storage := openTestStorage(t, path)
+	storage.SaveExpression(&Expression{ID: "1", Status: "pending", Node: parseNode(t, "(1 + 2) * (3 + 4)")})
+	storage.SaveResult("1", "0.0", Result{ID: 1, Result: 3}, nil)
+	storage.Close()
+
+	storage = openTestStorage(t, path)
+	o, err := NewOrchestratorWithStorage(storage)
+	if err != nil {
+		t.Fatalf("Failed to resume: %v", err)
+	}
+	expr := o.expressions["1"]
+
+	task := <-o.tasks
+	if task.Operation != "+" || task.Arg1 != 3 || task.Arg2 != 4 {
+		t.Fatalf("Expected only the unfinished subtree to be dispatched, got %+v", task)
+	}
+	o.deliver(Result{ID: task.ID, Result: 7})
+	task = <-o.tasks
+	if task.Operation != "*" || task.Arg1 != 3 || task.Arg2 != 7 {
+		t.Fatalf("Expected restored operand to be used, got %+v", task)
+	}
+	o.deliver(Result{ID: task.ID, Result: 21})
+
+	deadline := time.After(1 * time.Second)
+	for {
+		o.mu.Lock()
+		status := expr.Status
+		o.mu.Unlock()
+		if status == "completed" {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Timeout waiting for resumed expression")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	storage.Close()
+
+	storage = openTestStorage(t, path)
+	defer storage.Close()
+	state, err := storage.Load()
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	stored := state.Expressions
+	if len(stored) != 1 || stored[0].Expression.Status != "completed" || *stored[0].Expression.Result != 21 {
+		t.Errorf("Expected completed expression to be persisted, got %+v", stored[0].Expression)
+	}
+}
+
+func TestResumeKeepsTaskTimings(t *testing.T) {
+	setupEnv()
+	path := filepath.Join(t.TempDir(), "orchestrator.log")
+	storage := openTestStorage(t, path)
+	o, err := NewOrchestratorWithStorage(storage)
+	if err != nil {
+		t.Fatalf("Failed to open orchestrator: %v", err)
+	}
+	o.mu.Lock()
+	expr := o.newExpression("(1 + 2) * (3 + 4)", parseNode(t, "(1 + 2) * (3 + 4)"), "", 0, o.lookupVariable)
+	o.startExpression(expr)
+	o.mu.Unlock()
+
+	task, ok := o.lease(<-o.tasks)
+	if !ok {
+		t.Fatal("Failed to lease task")
+	}
+	o.deliver(Result{ID: task.ID, Result: 3})
+	deadline := time.After(1 * time.Second)
+	for {
+		storage.Flush()
+		records, _ := readRecords(path)
+		if slices.ContainsFunc(records, func(rec record) bool { return rec.Kind == recordResult }) {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Timeout waiting for result to be persisted")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	o.mu.Lock()
+	startedAt := *expr.StartedAt
+	finished := *expr.Tasks[slices.IndexFunc(expr.Tasks, func(info *TaskInfo) bool { return info.ID == task.ID })]
+	o.mu.Unlock()
+	storage.Close()
+
+	storage = openTestStorage(t, path)
+	defer storage.Close()
+	resumed, err := NewOrchestratorWithStorage(storage)
+	if err != nil {
+		t.Fatalf("Failed to resume: %v", err)
+	}
+	resumed.mu.Lock()
+	defer resumed.mu.Unlock()
+	restored := resumed.expressions[expr.ID]
+	if restored.StartedAt == nil || !restored.StartedAt.Equal(startedAt) {
+		t.Errorf("Expected started_at %v to survive a restart, got %v", startedAt, restored.StartedAt)
+	}
+	if len(restored.Tasks) == 0 {
+		t.Fatal("Expected the completed task to survive a restart")
+	}
+	info := restored.Tasks[0]
+	if info.ID != finished.ID || info.Status != "completed" || info.Attempts != 1 ||
+		info.StartedAt == nil || !info.StartedAt.Equal(*finished.StartedAt) ||
+		info.FinishedAt == nil || !info.FinishedAt.Equal(*finished.FinishedAt) {
+		t.Errorf("Expected task %+v to be restored, got %+v", finished, info)
+	}
+}